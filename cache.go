@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	OG        OG        `json:"og"`
+	FinalURL  string    `json:"finalURL,omitempty"`
+	FetchedAt time.Time `json:"fetchedAt"`
+
+	// validators from the response, sent back once the entry goes stale so
+	// an unchanged page costs a 304 instead of a full fetch
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// ogCache is an on-disk cache of fetched OG data keyed by target URL.
+// A nil *ogCache is valid and never hits.
+type ogCache struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+// loadCache reads the cache at path, starting empty when there is none yet.
+// A cache that exists but cannot be read is a config error.
+func loadCache(path string, ttl time.Duration) (*ogCache, error) {
+	c := &ogCache{path: path, ttl: ttl, entries: map[string]cacheEntry{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, withCode(exitConfig, err)
+	}
+	if err := json.Unmarshal(b, &c.entries); err != nil {
+		return nil, withCode(exitConfig, fmt.Errorf("%s: %w", path, err))
+	}
+	return c, nil
+}
+
+func (c *ogCache) get(target string) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[target]
+	if !ok || (c.ttl > 0 && time.Since(e.FetchedAt) > c.ttl) {
+		return cacheEntry{}, false
+	}
+	return e, true
+}
+
+// stale returns the entry for target that get considered expired, if the
+// server gave validators to revalidate it with.
+func (c *ogCache) stale(target string) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[target]
+	return e, ok && (e.ETag != "" || e.LastModified != "")
+}
+
+func (c *ogCache) fetchedAt(target string) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[target]
+	return e.FetchedAt, ok
+}
+
+func (c *ogCache) put(target string, e cacheEntry) {
+	if c == nil {
+		return
+	}
+	e.FetchedAt = time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[target] = e
+}
+
+// prune removes the entries whose target is not in keep and returns how many
+// it removed.
+func (c *ogCache) prune(keep map[string]bool) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for target := range c.entries {
+		if !keep[target] {
+			delete(c.entries, target)
+			n++
+		}
+	}
+	return n
+}
+
+// fetchTargets returns every URL a run fetches OG data for, i.e. what the
+// OG cache is keyed by.
+func (c *Config) fetchTargets() map[string]bool {
+	targets := map[string]bool{}
+	for _, r := range c.Routes {
+		targets[r.To] = true
+	}
+	if to := strings.TrimSpace(c.DefaultRedirect); to != "" {
+		targets[to] = true
+	}
+	return targets
+}
+
+func (c *ogCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOGCacheGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		age     time.Duration
+		wantHit bool
+	}{
+		{"fresh", 24 * time.Hour, time.Hour, true},
+		{"expired", 24 * time.Hour, 48 * time.Hour, false},
+		{"no ttl", 0, 365 * 24 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ogCache{ttl: tt.ttl, entries: map[string]cacheEntry{
+				"https://example.com/": {OG: OG{Title: "cached"}, FetchedAt: time.Now().Add(-tt.age)},
+			}}
+			e, ok := c.get("https://example.com/")
+			if ok != tt.wantHit {
+				t.Fatalf("get() hit = %v, want %v", ok, tt.wantHit)
+			}
+			if ok && e.OG.Title != "cached" {
+				t.Errorf("get() title = %q, want %q", e.OG.Title, "cached")
+			}
+			if _, ok := c.get("https://example.com/other"); ok {
+				t.Error("get() hit an unknown target")
+			}
+		})
+	}
+}
+
+func TestOGCacheStale(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry cacheEntry
+		want  bool
+	}{
+		{"etag", cacheEntry{ETag: `"v1"`}, true},
+		{"last modified", cacheEntry{LastModified: "Mon, 04 Mar 2024 10:00:00 GMT"}, true},
+		{"no validators", cacheEntry{OG: OG{Title: "cached"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ogCache{ttl: time.Hour, entries: map[string]cacheEntry{"https://example.com/": tt.entry}}
+			if _, ok := c.stale("https://example.com/"); ok != tt.want {
+				t.Errorf("stale() = %v, want %v", ok, tt.want)
+			}
+			if _, ok := c.stale("https://example.com/other"); ok {
+				t.Error("stale() found an unknown target")
+			}
+		})
+	}
+}
+
+func TestOGCacheSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "og-cache.json")
+	c, err := loadCache(path, time.Hour)
+	if err != nil {
+		t.Fatalf("loading a missing cache: %v", err)
+	}
+	c.put("https://example.com/a", cacheEntry{OG: OG{Title: "A", Image: "https://example.com/a.jpg"}, FinalURL: "https://example.com/a/"})
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = loadCache(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := c.get("https://example.com/a")
+	if !ok {
+		t.Fatal("saved entry missing after reload")
+	}
+	if e.OG.Title != "A" || e.OG.Image != "https://example.com/a.jpg" || e.FinalURL != "https://example.com/a/" {
+		t.Errorf("reloaded entry = %+v", e)
+	}
+}
+
+func TestOGCachePrune(t *testing.T) {
+	cfg := &Config{
+		DefaultRedirect: " https://shop.example.com/ ",
+		Routes: map[string]Route{
+			"/a": {To: "https://shop.example.com/a"},
+			"/b": {To: "https://shop.example.com/a"},
+			"/c": {To: "https://shop.example.com/c"},
+		},
+	}
+	tests := []struct {
+		name    string
+		entries []string
+		want    []string // kept, sorted
+		pruned  int
+	}{
+		{"nothing orphaned", []string{"https://shop.example.com/a", "https://shop.example.com/"}, []string{"https://shop.example.com/", "https://shop.example.com/a"}, 0},
+		{
+			name:    "orphans",
+			entries: []string{"https://shop.example.com/a", "https://shop.example.com/c", "https://shop.example.com/old", "https://elsewhere.example.com/a"},
+			want:    []string{"https://shop.example.com/a", "https://shop.example.com/c"},
+			pruned:  2,
+		},
+		{"only orphans", []string{"https://shop.example.com/old"}, nil, 1},
+		{"empty", nil, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "og-cache.json")
+			c, err := loadCache(path, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, target := range tt.entries {
+				c.put(target, cacheEntry{OG: OG{Title: target}})
+			}
+			if n := c.prune(cfg.fetchTargets()); n != tt.pruned {
+				t.Errorf("prune() = %d, want %d", n, tt.pruned)
+			}
+			if err := c.save(); err != nil {
+				t.Fatal(err)
+			}
+			if c, err = loadCache(path, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for target, e := range c.entries {
+				if e.OG.Title != target {
+					t.Errorf("entry for %s holds %q", target, e.OG.Title)
+				}
+				got = append(got, target)
+			}
+			slices.Sort(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilOGCache(t *testing.T) {
+	var c *ogCache
+	c.put("https://example.com/", cacheEntry{})
+	if _, ok := c.get("https://example.com/"); ok {
+		t.Error("nil cache hit")
+	}
+	if err := c.save(); err != nil {
+		t.Errorf("nil cache save: %v", err)
+	}
+}
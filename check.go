@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+type checkResult struct {
+	path   string
+	to     string
+	status int
+	err    error
+}
+
+func (r checkResult) ok() bool {
+	return r.err == nil && r.status < 400
+}
+
+// checkTarget issues a HEAD request to target, falling back to GET when the
+// server errors or rejects HEAD, and returns the final status code.
+func (f *fetcher) checkTarget(target string) (int, error) {
+	status, err := f.probe(http.MethodHead, target)
+	if err == nil && status < 400 {
+		return status, nil
+	}
+	return f.probe(http.MethodGet, target)
+}
+
+func (f *fetcher) probe(method, target string) (int, error) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	res, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	return res.StatusCode, nil
+}
+
+// runCheck probes every route target and logs one line per route plus a
+// summary. It returns the number of dead targets.
+func runCheck(cfg *Config, f *fetcher, concurrency int) int {
+	keys := sortedRoutes(cfg)
+	results := make([]checkResult, len(keys))
+	for i, p := range keys {
+		results[i] = checkResult{path: publicPath(cfg, p), to: cfg.Routes[p].To}
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *checkResult) {
+			defer func() { <-sem; wg.Done() }()
+			r.status, r.err = f.checkTarget(r.to)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	dead := 0
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			dead++
+			warnf("DEAD %s -> %s: %v", r.path, r.to, r.err)
+		case !r.ok():
+			dead++
+			warnf("DEAD %s -> %s: %d %s", r.path, r.to, r.status, http.StatusText(r.status))
+		default:
+			infof("ok   %s -> %s: %d", r.path, r.to, r.status)
+		}
+	}
+	infof("checked %d targets: %d ok, %d dead", len(results), len(results)-dead, dead)
+	return dead
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckTarget(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/head-error", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	mux.Handle("/moved", http.RedirectHandler("/ok", http.StatusMovedPermanently))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/ok", http.StatusOK},
+		{"/no-head", http.StatusOK},
+		{"/head-error", http.StatusOK},
+		{"/moved", http.StatusOK},
+		{"/missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			status, err := newFetcher(time.Second, "").checkTarget(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRunCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		routes   map[string]Route
+		wantDead int
+	}{
+		{"all ok", map[string]Route{"/a": {To: srv.URL + "/ok"}, "/b": {To: srv.URL + "/ok"}}, 0},
+		{"not found", map[string]Route{"/a": {To: srv.URL + "/ok"}, "/b": {To: srv.URL + "/gone"}}, 1},
+		{"unreachable", map[string]Route{"/a": {To: "http://127.0.0.1:1/"}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{CNAME: "s.example.com", Routes: tt.routes}
+			if dead := runCheck(cfg, newFetcher(time.Second, ""), 2); dead != tt.wantDead {
+				t.Errorf("runCheck() = %d dead, want %d", dead, tt.wantDead)
+			}
+		})
+	}
+}
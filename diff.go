@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed, '+' added.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns a unified diff turning old into new, labelled with
+// name. A nil old or new marks a created or deleted file.
+func unifiedDiff(name string, old, new []byte) string {
+	var sb strings.Builder
+	switch {
+	case old == nil:
+		fmt.Fprintf(&sb, "new file: %s\n--- /dev/null\n+++ b/%s\n", name, name)
+	case new == nil:
+		fmt.Fprintf(&sb, "deleted: %s\n--- a/%s\n+++ /dev/null\n", name, name)
+	default:
+		fmt.Fprintf(&sb, "modified: %s\n--- a/%s\n+++ b/%s\n", name, name, name)
+	}
+	if binary(old) || binary(new) {
+		sb.WriteString("Binary files differ\n")
+		return sb.String()
+	}
+	ops := diffLines(splitLines(old), splitLines(new))
+	for start := 0; start < len(ops); {
+		// find the next change and the run of ops its hunk covers
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		from := max(first-diffContext, start)
+		end, kept := first, 0
+		for end < len(ops) && kept <= 2*diffContext {
+			if ops[end].kind == ' ' {
+				kept++
+			} else {
+				kept = 0
+			}
+			end++
+		}
+		end -= max(kept-diffContext, 0)
+		writeHunk(&sb, ops, from, end)
+		start = end
+	}
+	return sb.String()
+}
+
+func writeHunk(sb *strings.Builder, ops []diffOp, from, end int) {
+	oldLine, newLine := 1, 1
+	for _, op := range ops[:from] {
+		if op.kind != '+' {
+			oldLine++
+		}
+		if op.kind != '-' {
+			newLine++
+		}
+	}
+	oldN, newN := 0, 0
+	for _, op := range ops[from:end] {
+		if op.kind != '+' {
+			oldN++
+		}
+		if op.kind != '-' {
+			newN++
+		}
+	}
+	// empty ranges point at the line before, as diff(1) does
+	if oldN == 0 {
+		oldLine--
+	}
+	if newN == 0 {
+		newLine--
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", oldLine, oldN, newLine, newN)
+	for _, op := range ops[from:end] {
+		sb.WriteByte(op.kind)
+		sb.WriteString(op.line)
+		sb.WriteByte('\n')
+	}
+}
+
+// diffLines computes a shortest edit script via the longest common
+// subsequence. Generated files are small, so the quadratic table is fine;
+// past a size limit the whole file is shown as replaced instead.
+func diffLines(a, b []string) []diffOp {
+	var ops []diffOp
+	if len(a)*len(b) > 4<<20 {
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+		return ops
+	}
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+func splitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func binary(b []byte) bool {
+	return bytes.IndexByte(b, 0) >= 0 || !utf8.Valid(b)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(n int, change map[int]string) []byte {
+		var sb strings.Builder
+		for i := 1; i <= n; i++ {
+			if l, ok := change[i]; ok {
+				if l != "" {
+					sb.WriteString(l + "\n")
+				}
+				continue
+			}
+			fmt.Fprintf(&sb, "line %d\n", i)
+		}
+		return []byte(sb.String())
+	}
+	tests := []struct {
+		name     string
+		old, new []byte
+		want     string
+	}{
+		{
+			name: "new file",
+			old:  nil,
+			new:  []byte("a\nb\n"),
+			want: "new file: f\n--- /dev/null\n+++ b/f\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "deleted file",
+			old:  []byte("a\n"),
+			new:  nil,
+			want: "deleted: f\n--- a/f\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "one line changed",
+			old:  lines(10, nil),
+			new:  lines(10, map[int]string{5: "line five"}),
+			want: "modified: f\n--- a/f\n+++ b/f\n@@ -2,7 +2,7 @@\n line 2\n line 3\n line 4\n-line 5\n+line five\n line 6\n line 7\n line 8\n",
+		},
+		{
+			name: "separate hunks",
+			old:  lines(20, nil),
+			new:  lines(20, map[int]string{2: "line two", 18: ""}),
+			want: "modified: f\n--- a/f\n+++ b/f\n" +
+				"@@ -1,5 +1,5 @@\n line 1\n-line 2\n+line two\n line 3\n line 4\n line 5\n" +
+				"@@ -15,6 +15,5 @@\n line 15\n line 16\n line 17\n-line 18\n line 19\n line 20\n",
+		},
+		{
+			name: "binary",
+			old:  []byte("\x89PNG\x00\x01"),
+			new:  []byte("\x89PNG\x00\x02"),
+			want: "modified: f\n--- a/f\n+++ b/f\nBinary files differ\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("f", tt.old, tt.new); got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateDiff(t *testing.T) {
+	site := newSite(t, map[string]string{
+		"/p": `<meta property="og:title" content="Cap">`,
+		"/q": `<meta property="og:title" content="Hoodie">`,
+	})
+	o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b": "%[1]s/p", "/c": "%[1]s/p"}}`, site.URL))
+	if err := generate(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	before := readOut(t, o.outDir)
+
+	// /a changes its target, /b goes away, /d is new and /c stays the same
+	writeFile(t, o.cfgPath, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%[1]s/q", "/c": "%[1]s/p", "/d": "%[1]s/p"}}`, site.URL))
+	o.diff, o.clean = true, true
+	stdout := captureOutput(t, &os.Stdout)
+	if err := generate(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	got := stdout()
+
+	var files []string
+	for _, line := range strings.Split(got, "\n") {
+		for _, prefix := range []string{"new file: ", "modified: ", "deleted: "} {
+			if strings.HasPrefix(line, prefix) {
+				files = append(files, line)
+			}
+		}
+	}
+	want := []string{"modified: a/index.html", "new file: d/index.html", "deleted: b/index.html"}
+	if strings.Join(files, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diff covers %q, want %q:\n%s", files, want, got)
+	}
+	for _, w := range []string{
+		`-<meta property="og:title" content="Cap">`,
+		`+<meta property="og:title" content="Hoodie">`,
+	} {
+		if !strings.Contains(got, w) {
+			t.Errorf("diff does not contain %s", w)
+		}
+	}
+	if after := readOut(t, o.outDir); len(after) != len(before) || after["a/index.html"] != before["a/index.html"] {
+		t.Error("-diff changed the output")
+	}
+}
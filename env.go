@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envRef matches ${VAR} and ${VAR:-default}.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// verbatimKeys are the top-level config fields holding raw HTML. They are
+// inserted as written, since ${...} there is a JavaScript template literal
+// far more often than an environment reference.
+var verbatimKeys = map[string]bool{"headInclude": true, "bodyInclude": true}
+
+// expandEnv replaces ${VAR} references in every string value of a decoded
+// config document except the verbatimKeys. Unset variables without a default
+// expand to "", or are reported as an error when strict is set.
+func expandEnv(v any, strict bool) (any, error) {
+	var missing []string
+	var walk func(v any) any
+	walk = func(v any) any {
+		switch v := v.(type) {
+		case string:
+			return envRef.ReplaceAllStringFunc(v, func(ref string) string {
+				m := envRef.FindStringSubmatch(ref)
+				if val, ok := os.LookupEnv(m[1]); ok {
+					return val
+				}
+				if strings.Contains(ref, ":-") {
+					return m[2]
+				}
+				missing = append(missing, m[1])
+				return ""
+			})
+		case map[string]any:
+			for k, e := range v {
+				v[k] = walk(e)
+			}
+		case []any:
+			for i, e := range v {
+				v[i] = walk(e)
+			}
+		}
+		return v
+	}
+	if top, ok := v.(map[string]any); ok {
+		for k, e := range top {
+			if !verbatimKeys[k] {
+				top[k] = walk(e)
+			}
+		}
+	} else {
+		v = walk(v)
+	}
+	if strict && len(missing) > 0 {
+		return nil, fmt.Errorf("unset environment variable(s): %s", strings.Join(missing, ", "))
+	}
+	return v, nil
+}
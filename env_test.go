@@ -0,0 +1,96 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("SHOP_HOST", "shop.example.com")
+	t.Setenv("UTM_SOURCE", "qr")
+	tests := []struct {
+		name    string
+		v       any
+		strict  bool
+		want    any
+		wantErr bool
+	}{
+		{"no references", "https://shop.example.com/", false, "https://shop.example.com/", false},
+		{"substitution", "https://${SHOP_HOST}/p", false, "https://shop.example.com/p", false},
+		{"several", "${SHOP_HOST}/${UTM_SOURCE}", false, "shop.example.com/qr", false},
+		{"default unused", "${SHOP_HOST:-dev.example.com}", false, "shop.example.com", false},
+		{"default", "${UNIGOODS_UNSET:-dev.example.com}", false, "dev.example.com", false},
+		{"empty default", "a${UNIGOODS_UNSET:-}b", true, "ab", false},
+		{"missing", "https://${UNIGOODS_UNSET}/", false, "https:///", false},
+		{"missing under strict", "https://${UNIGOODS_UNSET}/", true, nil, true},
+		{"bare $VAR is left alone", "$SHOP_HOST", true, "$SHOP_HOST", false},
+		{
+			name: "nested values",
+			v:    map[string]any{"cname": "${SHOP_HOST}", "routes": map[string]any{"/a": "https://${SHOP_HOST}/a"}, "hosts": []any{"${SHOP_HOST}", 2.0}},
+			want: map[string]any{"cname": "shop.example.com", "routes": map[string]any{"/a": "https://shop.example.com/a"}, "hosts": []any{"shop.example.com", 2.0}},
+		},
+		{
+			name:   "includes are verbatim",
+			v:      map[string]any{"cname": "${SHOP_HOST}", "headInclude": "<script>var s = `${SHOP_HOST}`;</script>", "bodyInclude": "<script>var s = `hi ${name}`;</script>"},
+			strict: true,
+			want:   map[string]any{"cname": "shop.example.com", "headInclude": "<script>var s = `${SHOP_HOST}`;</script>", "bodyInclude": "<script>var s = `hi ${name}`;</script>"},
+		},
+		{
+			name: "include keys below the top level are expanded",
+			v:    map[string]any{"routes": map[string]any{"bodyInclude": "${SHOP_HOST}"}},
+			want: map[string]any{"routes": map[string]any{"bodyInclude": "shop.example.com"}},
+		},
+		{"keys are not expanded", map[string]any{"${SHOP_HOST}": "x"}, false, map[string]any{"${SHOP_HOST}": "x"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandEnv(tt.v, tt.strict)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandEnv() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandEnv() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	t.Setenv("SHOP_HOST", "shop.example.com")
+	path := filepath.Join(t.TempDir(), "routes.json")
+	writeFile(t, path, `{"cname": "${SITE_HOST:-s.example.com}", "routes": {"/a": "https://${SHOP_HOST}/a", "/b": "https://${UNIGOODS_UNSET}/b"}}`)
+
+	cfg, err := loadConfig(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CNAME != "s.example.com" || cfg.Routes["/a"].To != "https://shop.example.com/a" {
+		t.Errorf("config = %+v", cfg)
+	}
+	if _, err := loadConfig(path, true); exitCode(err) != exitConfig || !strings.Contains(err.Error(), "UNIGOODS_UNSET") {
+		t.Errorf("loadConfig() with -strict-env = %v, want a config error naming UNIGOODS_UNSET", err)
+	}
+}
+
+func TestLoadConfigEnvIncludes(t *testing.T) {
+	const script = "<script>var s = `hi ${name}`;</script>"
+	path := filepath.Join(t.TempDir(), "routes.json")
+	writeFile(t, path, `{"cname": "s.example.com", "bodyInclude": "<script>var s = `+"`hi ${name}`"+`;</script>", "routes": {"/a": "https://shop.example.com/a"}}`)
+
+	cfg, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatalf("loadConfig() with -strict-env = %v", err)
+	}
+	if cfg.BodyInclude != script {
+		t.Errorf("bodyInclude = %q, want %q", cfg.BodyInclude, script)
+	}
+	page, err := buildHTML(nil, cfg, "/a", cfg.Routes["/a"], OG{Title: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page, script) {
+		t.Errorf("page does not contain %s:\n%s", script, page)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import "errors"
+
+// Exit codes, so scripts and CI can tell failure classes apart.
+const (
+	exitFailure = 1 // anything not listed below, e.g. an interrupted run
+	exitConfig  = 2 // invalid flags, config, cache, templates or routes, or a -strict check
+	exitFetch   = 3 // dead targets under -check, OG failures under -fail-on-og-error
+	exitWrite   = 4 // the output, cache, manifest or report could not be written
+)
+
+const exitCodesHelp = `
+Exit codes:
+  1  other failures, e.g. an interrupted run
+  2  invalid flags, config, cache, templates or routes, or a failed -strict check
+  3  dead targets with -check, failed OG fetches with -fail-on-og-error
+  4  the output, cache, manifest or report could not be written
+`
+
+// codedError carries the exit code for err up to main.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// withCode tags err with an exit code. A nil err stays nil, and an error
+// already tagged keeps its code.
+func withCode(code int, err error) error {
+	var ce *codedError
+	if err == nil || errors.As(err, &ce) {
+		return err
+	}
+	return &codedError{code: code, err: err}
+}
+
+// exitCode returns the code err was tagged with, or exitFailure.
+func exitCode(err error) int {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return exitFailure
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithCode(t *testing.T) {
+	base := errors.New("boom")
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{"untagged", base, exitFailure},
+		{"tagged", withCode(exitWrite, base), exitWrite},
+		{"first tag wins", withCode(exitConfig, withCode(exitFetch, base)), exitFetch},
+		{"wrapped", fmt.Errorf("route /a: %w", withCode(exitConfig, base)), exitConfig},
+		{"tagged after wrapping", withCode(exitWrite, fmt.Errorf("writing: %w", base)), exitWrite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.wantCode {
+				t.Errorf("exitCode() = %d, want %d", got, tt.wantCode)
+			}
+			if !errors.Is(tt.err, base) {
+				t.Errorf("%v does not wrap the original error", tt.err)
+			}
+		})
+	}
+	if err := withCode(exitConfig, nil); err != nil {
+		t.Errorf("withCode(nil) = %v, want nil", err)
+	}
+}
+
+func TestGenerateExitCodes(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	const routes = `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`
+	tests := []struct {
+		name     string
+		cfg      string // %[1]s is the test site's URL
+		opts     func(t *testing.T, o *options)
+		cancel   bool
+		wantCode int
+	}{
+		{"ok", routes, nil, false, 0},
+		{"malformed config", `{"routes": `, nil, false, exitConfig},
+		{"missing config", routes, func(t *testing.T, o *options) { o.cfgPath += ".missing" }, false, exitConfig},
+		{"no routes", `{"cname": "s.example.com"}`, nil, false, exitConfig},
+		{"invalid target", `{"cname": "s.example.com", "routes": {"/a": "javascript:alert(1)"}}`, nil, false, exitConfig},
+		{"every route skipped with -skip-invalid", `{"cname": "s.example.com", "routes": {"/a": "javascript:alert(1)"}}`, func(t *testing.T, o *options) { o.skipInvalid = true }, false, exitConfig},
+		{"every route skipped with -skip-invalid and -allow-empty", `{"cname": "s.example.com", "routes": {"/a": "javascript:alert(1)"}}`, func(t *testing.T, o *options) { o.skipInvalid, o.allowEmpty = true, true }, false, 0},
+		{"some routes skipped with -skip-invalid", `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b": "javascript:alert(1)"}}`, func(t *testing.T, o *options) { o.skipInvalid = true }, false, 0},
+		{"broken template", routes, func(t *testing.T, o *options) {
+			o.templatePath = filepath.Join(t.TempDir(), "page.html")
+			writeFile(t, o.templatePath, "{{.Title")
+		}, false, exitConfig},
+		{"over budget with -strict", routes, func(t *testing.T, o *options) { o.pageBudget, o.strict = 100, true }, false, exitConfig},
+		{"failed OG fetch with -fail-on-og-error", `{"cname": "s.example.com", "routes": {"/a": "%[1]s/gone"}}`, func(t *testing.T, o *options) { o.failOnOGError = true }, false, exitFetch},
+		{"unwritable output", routes, func(t *testing.T, o *options) { o.outDir = filepath.Join(fileInTheWay(t), "out") }, false, exitWrite},
+		{"unreadable cache", routes, func(t *testing.T, o *options) { o.cachePath = filepath.Join(fileInTheWay(t), "cache.json") }, false, exitConfig},
+		{"corrupt cache", routes, func(t *testing.T, o *options) {
+			o.cachePath = filepath.Join(t.TempDir(), "cache.json")
+			writeFile(t, o.cachePath, "{")
+		}, false, exitConfig},
+		{"unwritable cache", routes, func(t *testing.T, o *options) { o.cachePath = filepath.Join(t.TempDir(), "missing", "cache.json") }, false, exitWrite},
+		{"unwritable manifest", routes, func(t *testing.T, o *options) { o.manifestPath = filepath.Join(fileInTheWay(t), "manifest.json") }, false, exitWrite},
+		{"unwritable report", routes, func(t *testing.T, o *options) { o.reportPath = filepath.Join(fileInTheWay(t), "report.json") }, false, exitWrite},
+		{"interrupted", routes, nil, true, exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, strings.ReplaceAll(tt.cfg, "%[1]s", site.URL))
+			if tt.opts != nil {
+				tt.opts(t, o)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			err := generate(ctx, o)
+			switch {
+			case tt.wantCode == 0 && err != nil:
+				t.Fatalf("generate() = %v, want no error", err)
+			case tt.wantCode != 0 && err == nil:
+				t.Fatalf("generate() succeeded, want exit code %d", tt.wantCode)
+			case err != nil && exitCode(err) != tt.wantCode:
+				t.Errorf("generate() = %v with exit code %d, want %d", err, exitCode(err), tt.wantCode)
+			}
+		})
+	}
+}
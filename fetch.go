@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+type routeJob struct {
+	path  string
+	to    string
+	route Route
+	og    OG
+	final string
+	err   error
+
+	fetchedAt time.Time
+	duration  time.Duration
+	stats     fetchStats
+}
+
+// fetchStats describes the response behind a fetch for -report.
+type fetchStats struct {
+	status int   // of the last attempt; 0 when cached or nothing came back
+	bytes  int64 // decoded body bytes read before parsing stopped
+	cached bool
+}
+
+// fetcher holds the settings shared by every OG fetch of a run.
+type fetcher struct {
+	client    *http.Client
+	userAgent string
+	cache     *ogCache
+	retries   int
+	limit     *hostLimiter
+	maxBody   int64
+	progress  *progress
+}
+
+const (
+	defaultTimeout   = 12 * time.Second
+	defaultUserAgent = "Mozilla/5.0"
+	// OG tags normally sit in <head>, but some pages inject them late, so
+	// the limit only bounds memory rather than expecting them early
+	defaultMaxBody = 8 << 20
+)
+
+func newFetcher(timeout time.Duration, userAgent string) *fetcher {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	return &fetcher{client: &http.Client{Timeout: timeout}, userAgent: userAgent, maxBody: defaultMaxBody}
+}
+
+// fetchAll fetches OG data for every job using at most concurrency workers.
+// Results are stored on the jobs in place so callers keep their ordering.
+// Once ctx is done the remaining jobs fail with its error.
+func (f *fetcher) fetchAll(ctx context.Context, jobs []routeJob, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				if err := ctx.Err(); err != nil {
+					jobs[i].err = err
+					f.progress.step(jobs[i].to)
+					continue
+				}
+				start := time.Now()
+				jobs[i].og, jobs[i].final, jobs[i].stats, jobs[i].err = f.fetchOG(ctx, jobs[i].to)
+				jobs[i].duration = time.Since(start)
+				jobs[i].fetchedAt = time.Now()
+				if t, ok := f.cache.fetchedAt(jobs[i].to); ok && jobs[i].err == nil {
+					jobs[i].fetchedAt = t
+				}
+				f.progress.step(jobs[i].to)
+			}
+		}()
+	}
+	for i := range jobs {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+}
+
+// statusError reports a non-2xx response from a target after redirects.
+type statusError struct {
+	Code       int
+	URL        string
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s responded %d %s", e.URL, e.Code, http.StatusText(e.Code))
+}
+
+// fetchOG returns the OG data of target along with the final URL reached
+// after following redirects. Network errors, 429 and 5xx responses are
+// retried up to f.retries times with exponential backoff. Every attempt,
+// retries included, goes through the per-host rate limit. Expired cache
+// entries with an ETag or Last-Modified are revalidated with a conditional
+// request, and a 304 reuses the cached OG without reparsing.
+func (f *fetcher) fetchOG(ctx context.Context, target string) (OG, string, fetchStats, error) {
+	if e, ok := f.cache.get(target); ok {
+		return e.OG, e.FinalURL, fetchStats{cached: true}, nil
+	}
+	prev, conditional := f.cache.stale(target)
+	var (
+		og    OG
+		final string
+		st    fetchStats
+		v     validators
+		err   error
+	)
+	for attempt := 0; ; attempt++ {
+		if err = f.limit.wait(ctx, target); err != nil {
+			break
+		}
+		st, v = fetchStats{}, validators{}
+		if conditional {
+			v = validators{etag: prev.ETag, lastModified: prev.LastModified}
+		}
+		og, final, err = f.fetchOnce(ctx, target, &st, &v)
+		if err == nil || attempt >= f.retries || !retryable(err) || ctx.Err() != nil {
+			break
+		}
+		wait := backoff(attempt, err)
+		warnf("retrying %s in %v: %v", target, wait.Round(time.Millisecond), err)
+		if err = sleep(ctx, wait); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		return og, final, st, err
+	}
+	if st.status == http.StatusNotModified {
+		og, final, st.cached = prev.OG, prev.FinalURL, true
+	}
+	f.cache.put(target, cacheEntry{OG: og, FinalURL: final, ETag: v.etag, LastModified: v.lastModified})
+	return og, final, st, nil
+}
+
+// validators are the conditional-request headers of a cached response. They
+// go out with the request when set and come back from the response.
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+func (f *fetcher) fetchOnce(ctx context.Context, target string, st *fetchStats, v *validators) (OG, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return OG{}, "", err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Language", "ko-KR,ko;q=0.9,en-US;q=0.8,en;q=0.7")
+	// setting Accept-Encoding disables the transport's transparent gzip, so
+	// bodies are decoded by decodeBody instead
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return OG{}, "", err
+	}
+	defer res.Body.Close()
+	// the client follows redirects, so res.Request is the last hop
+	final := res.Request.URL.String()
+	st.status = res.StatusCode
+	if res.StatusCode == http.StatusNotModified && (v.etag != "" || v.lastModified != "") {
+		// the caller reuses the cached OG; keep the validators unless the
+		// server sent fresher ones
+		v.etag = cmp.Or(res.Header.Get("ETag"), v.etag)
+		v.lastModified = cmp.Or(res.Header.Get("Last-Modified"), v.lastModified)
+		return OG{}, final, nil
+	}
+	v.etag, v.lastModified = res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return OG{}, final, &statusError{
+			Code:       res.StatusCode,
+			URL:        final,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+
+	ct := res.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(ct); ct != "" && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		// a PDF or an image has no OG data, but the target is fine: the
+		// fallbacks fill the page and the empty result is cached
+		infof("%s is %s, not HTML; using fallbacks", final, ct)
+		return OG{}, final, nil
+	}
+
+	r, err := decodeBody(res)
+	if err != nil {
+		return OG{}, final, err
+	}
+	cr := &countingReader{r: io.LimitReader(r, f.maxBody)}
+	og, err := parseOG(cr, ct, final)
+	st.bytes = cr.n
+	if err != nil {
+		return OG{}, final, err
+	}
+	return og, final, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodeBody wraps res.Body according to its Content-Encoding. Servers may
+// compress even when not asked to, so the header is trusted either way.
+// Transfer-Encoding (chunked) is already undone by net/http, and stacked
+// codings such as "gzip, br" are removed last-applied first. Callers limit
+// the decoded stream, so the body limit counts uncompressed bytes.
+func decodeBody(res *http.Response) (io.Reader, error) {
+	codings := strings.Split(res.Header.Get("Content-Encoding"), ",")
+	var r io.Reader = res.Body
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		if r, err = decodeCoding(r, strings.ToLower(strings.TrimSpace(codings[i]))); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func decodeCoding(r io.Reader, coding string) (io.Reader, error) {
+	switch coding {
+	case "", "identity":
+		return r, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// "deflate" is meant to be zlib-wrapped, but some servers send raw
+		// DEFLATE; a zlib stream starts with a 0x78 CMF byte
+		br := bufio.NewReader(r)
+		if b, err := br.Peek(1); err == nil && b[0] == 0x78 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", coding)
+	}
+}
+
+// retryable reports whether err is worth another attempt: transport errors,
+// rate limiting and server errors are; other HTTP statuses are not.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.Code == http.StatusTooManyRequests || se.Code >= 500
+	}
+	return true
+}
+
+// maxRetryAfter caps the server's Retry-After so a misconfigured or hostile
+// server can't stall the build for hours.
+const maxRetryAfter = 30 * time.Second
+
+// backoff returns the wait before retry attempt+1: the server's Retry-After
+// when given, up to maxRetryAfter, otherwise 500ms doubled per attempt plus
+// up to 50% jitter.
+func backoff(attempt int, err error) time.Duration {
+	var se *statusError
+	if errors.As(err, &se) && se.RetryAfter > 0 {
+		return min(se.RetryAfter, maxRetryAfter)
+	}
+	d := 500 * time.Millisecond << attempt
+	return d + rand.N(d/2+1)
+}
+
+// sleep waits for d or until ctx is done, whichever comes first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// parseRetryAfter accepts both the delay-seconds and HTTP-date forms.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestFetchAll(t *testing.T) {
+	tests := []struct {
+		concurrency int
+		wantMax     int
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.concurrency), func(t *testing.T) {
+			var mu sync.Mutex
+			inFlight, peak := 0, 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				inFlight++
+				peak = max(peak, inFlight)
+				mu.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				fmt.Fprintf(w, `<meta property="og:title" content="%s">`, r.URL.Path)
+			}))
+			defer srv.Close()
+
+			jobs := make([]routeJob, 8)
+			for i := range jobs {
+				jobs[i].to = fmt.Sprintf("%s/p%d", srv.URL, i)
+			}
+			newFetcher(time.Second, "").fetchAll(context.Background(), jobs, tt.concurrency)
+			for i, j := range jobs {
+				if j.err != nil {
+					t.Fatalf("job %d: %v", i, j.err)
+				}
+				if want := fmt.Sprintf("/p%d", i); j.og.Title != want {
+					t.Errorf("job %d: title %q, want %q", i, j.og.Title, want)
+				}
+			}
+			if peak > tt.wantMax {
+				t.Errorf("%d fetches in flight, want at most %d", peak, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestFetchOGStatus(t *testing.T) {
+	tests := []struct {
+		status   int
+		wantCode int // 0 for no error
+	}{
+		{http.StatusOK, 0},
+		{http.StatusNoContent, 0},
+		{http.StatusNotFound, http.StatusNotFound},
+		{http.StatusGone, http.StatusGone},
+		{http.StatusInternalServerError, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			_, _, st, err := newFetcher(time.Second, "").fetchOG(context.Background(), srv.URL)
+			var se *statusError
+			switch {
+			case tt.wantCode == 0 && err != nil:
+				t.Fatalf("fetchOG() = %v, want no error", err)
+			case tt.wantCode != 0 && !errors.As(err, &se):
+				t.Fatalf("fetchOG() = %v, want a statusError", err)
+			case tt.wantCode != 0 && se.Code != tt.wantCode:
+				t.Errorf("status error code %d, want %d", se.Code, tt.wantCode)
+			}
+			if st.status != tt.status {
+				t.Errorf("stats status %d, want %d", st.status, tt.status)
+			}
+		})
+	}
+}
+
+func TestFetchOGFinalURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/moved", http.StatusMovedPermanently))
+	mux.Handle("/moved", http.RedirectHandler("/p?id=1", http.StatusFound))
+	mux.HandleFunc("/p", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<meta property="og:title" content="Product">`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		path, wantFinal string
+	}{
+		{"/p?id=1", "/p?id=1"},
+		{"/moved", "/p?id=1"},
+		{"/old", "/p?id=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			og, final, _, err := newFetcher(time.Second, "").fetchOG(context.Background(), srv.URL+tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if final != srv.URL+tt.wantFinal {
+				t.Errorf("final URL %q, want %q", final, srv.URL+tt.wantFinal)
+			}
+			if og.Title != "Product" {
+				t.Errorf("title %q, want %q", og.Title, "Product")
+			}
+		})
+	}
+}
+
+func TestFetchOGRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failStatus   int
+		failures     int
+		retries      int
+		wantRequests int
+		wantErr      bool
+	}{
+		{"recovers", http.StatusServiceUnavailable, 1, 1, 2, false},
+		{"out of retries", http.StatusServiceUnavailable, 1, 0, 1, true},
+		{"rate limited", http.StatusTooManyRequests, 1, 2, 2, false},
+		{"not found is final", http.StatusNotFound, 1, 2, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				n := requests
+				mu.Unlock()
+				if n <= tt.failures {
+					w.WriteHeader(tt.failStatus)
+					return
+				}
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<meta property="og:title" content="ok">`)
+			}))
+			defer srv.Close()
+
+			f := newFetcher(time.Second, "")
+			f.retries = tt.retries
+			og, _, _, err := f.fetchOG(context.Background(), srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchOG() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && og.Title != "ok" {
+				t.Errorf("title %q, want %q", og.Title, "ok")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if requests != tt.wantRequests {
+				t.Errorf("%d requests, want %d", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempt  int
+		err      error
+		min, max time.Duration
+	}{
+		{"first retry", 0, errors.New("reset"), 500 * time.Millisecond, 750 * time.Millisecond},
+		{"doubles", 2, errors.New("reset"), 2 * time.Second, 3 * time.Second},
+		{"retry-after", 0, &statusError{Code: 429, RetryAfter: 5 * time.Second}, 5 * time.Second, 5 * time.Second},
+		{"retry-after is capped", 0, &statusError{Code: 503, RetryAfter: 2 * time.Hour}, maxRetryAfter, maxRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if d := backoff(tt.attempt, tt.err); d < tt.min || d > tt.max {
+				t.Errorf("backoff() = %v, want between %v and %v", d, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 50 * time.Second, time.Minute},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if d := parseRetryAfter(tt.value); d < tt.min || d > tt.max {
+				t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.value, d, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestFetchOGContentEncoding(t *testing.T) {
+	const page = `<html><head><meta property="og:title" content="Compressed"></head></html>`
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		chunked  bool
+		wantErr  bool
+	}{
+		{"identity", "", []byte(page), false, false},
+		{"gzip", "gzip", compress(t, "gzip", page), false, false},
+		{"x-gzip", "x-gzip", compress(t, "gzip", page), false, false},
+		{"deflate", "deflate", compress(t, "zlib", page), false, false},
+		{"raw deflate", "deflate", compress(t, "flate", page), false, false},
+		{"brotli", "br", compress(t, "br", page), false, false},
+		{"uppercase", "GZIP", compress(t, "gzip", page), false, false},
+		{"stacked", "gzip, br", compress(t, "br", string(compress(t, "gzip", page))), false, false},
+		{"chunked identity", "", []byte(page), true, false},
+		{"chunked gzip", "gzip", compress(t, "gzip", page), true, false},
+		{"chunked brotli", "br", compress(t, "br", page), true, false},
+		{"unsupported", "compress", []byte(page), false, true},
+		{"unsupported in a stack", "gzip, compress", compress(t, "gzip", page), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				if !tt.chunked {
+					w.Write(tt.body)
+					return
+				}
+				// flushing before the end drops Content-Length for chunked framing
+				for b := tt.body; len(b) > 0; {
+					n := min(len(b), 16)
+					w.Write(b[:n])
+					w.(http.Flusher).Flush()
+					b = b[n:]
+				}
+			}))
+			defer srv.Close()
+
+			f := newFetcher(time.Second, "")
+			f.retries = 0
+			og, _, _, err := f.fetchOG(context.Background(), srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchOG() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && og.Title != "Compressed" {
+				t.Errorf("title %q, want %q", og.Title, "Compressed")
+			}
+		})
+	}
+}
+
+// compress encodes s with the named format: gzip, zlib, flate or br.
+func compress(t *testing.T, format, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch format {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	case "flate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown format %q", format)
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFetcherSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		timeout   time.Duration
+		userAgent string
+		delay     time.Duration
+		wantUA    string
+		wantErr   bool
+	}{
+		{"defaults", 0, "", 0, defaultUserAgent, false},
+		{"custom user agent", time.Second, "unigoods-bot/1.0", 0, "unigoods-bot/1.0", false},
+		{"timeout", 50 * time.Millisecond, "", 500 * time.Millisecond, defaultUserAgent, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ua := make(chan string, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ua <- r.UserAgent()
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+				}
+				w.Header().Set("Content-Type", "text/html")
+			}))
+			defer srv.Close()
+
+			f := newFetcher(tt.timeout, tt.userAgent)
+			f.retries = 0
+			if _, _, _, err := f.fetchOG(context.Background(), srv.URL); (err != nil) != tt.wantErr {
+				t.Fatalf("fetchOG() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotUA := <-ua; gotUA != tt.wantUA {
+				t.Errorf("User-Agent %q, want %q", gotUA, tt.wantUA)
+			}
+		})
+	}
+}
+
+func TestFetchOGContentType(t *testing.T) {
+	const page = `<html><head><meta property="og:title" content="Parsed"></head></html>`
+	tests := []struct {
+		name        string
+		contentType string // "none" sends no Content-Type at all
+		body        string
+		wantTitle   string
+	}{
+		{"html", "text/html", page, "Parsed"},
+		{"html with charset", "text/html; charset=utf-8", page, "Parsed"},
+		{"uppercase", "TEXT/HTML", page, "Parsed"},
+		{"xhtml", "application/xhtml+xml", page, "Parsed"},
+		{"no content type", "none", page, "Parsed"},
+		{"json", "application/json", `{"html": "` + strings.ReplaceAll(page, `"`, `\"`) + `"}`, ""},
+		{"image", "image/png", "\x89PNG\r\n\x1a\n" + page, ""},
+		{"plain text", "text/plain", page, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType == "none" {
+					w.Header()["Content-Type"] = nil
+				} else {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				io.WriteString(w, tt.body)
+			}))
+			defer srv.Close()
+
+			og, _, _, err := newFetcher(time.Second, "").fetchOG(context.Background(), srv.URL)
+			if err != nil {
+				t.Fatalf("fetchOG() = %v, want no error", err)
+			}
+			if og.Title != tt.wantTitle {
+				t.Errorf("title %q, want %q", og.Title, tt.wantTitle)
+			}
+		})
+	}
+}
+
+func TestFetchOGBodyLimit(t *testing.T) {
+	// tags injected late, after 2 MiB of inline script
+	page := `<html><head><script>` + strings.Repeat("x", 2<<20) + `</script>
+		<meta property="og:title" content="Late"></head><body></body></html>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if enc := strings.TrimPrefix(r.URL.Path, "/"); enc != "" {
+			w.Header().Set("Content-Encoding", enc)
+			w.Write(compress(t, enc, page))
+			return
+		}
+		io.WriteString(w, page)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		encoding  string
+		maxBody   int64 // 0 keeps the default
+		wantTitle string
+		wantBytes int64 // at most
+	}{
+		{"default limit", "", 0, "Late", int64(len(page))},
+		{"limit past the tags", "", 3 << 20, "Late", int64(len(page))},
+		{"limit before the tags", "", 1 << 20, "", 1 << 20},
+		// the compressed body is tiny; the limit counts decoded bytes
+		{"gzip past the tags", "gzip", 3 << 20, "Late", int64(len(page))},
+		{"brotli past the tags", "br", 3 << 20, "Late", int64(len(page))},
+		{"brotli before the tags", "br", 1 << 20, "", 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFetcher(5*time.Second, "")
+			if tt.maxBody > 0 {
+				f.maxBody = tt.maxBody
+			}
+			og, _, st, err := f.fetchOG(context.Background(), srv.URL+"/"+tt.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if og.Title != tt.wantTitle {
+				t.Errorf("title %q, want %q", og.Title, tt.wantTitle)
+			}
+			if st.bytes > tt.wantBytes {
+				t.Errorf("read %d bytes, want at most %d", st.bytes, tt.wantBytes)
+			}
+		})
+	}
+}
+
+func TestFetchOGConditional(t *testing.T) {
+	const lastMod = "Mon, 04 Mar 2024 10:00:00 GMT"
+	tests := []struct {
+		name      string
+		entry     *cacheEntry // nil leaves the cache empty
+		age       time.Duration
+		respETag  string // sent along with a 304
+		wantINM   string
+		wantIMS   string
+		wantHits  int
+		wantTitle string
+		wantCache bool
+		wantETag  string // stored afterwards
+	}{
+		{
+			name:      "etag matches",
+			entry:     &cacheEntry{OG: OG{Title: "Cached"}, ETag: `"v1"`},
+			age:       2 * time.Hour,
+			wantINM:   `"v1"`,
+			wantHits:  1,
+			wantTitle: "Cached",
+			wantCache: true,
+			wantETag:  `"v1"`,
+		},
+		{
+			name:      "last modified matches",
+			entry:     &cacheEntry{OG: OG{Title: "Cached"}, LastModified: lastMod},
+			age:       2 * time.Hour,
+			wantIMS:   lastMod,
+			wantHits:  1,
+			wantTitle: "Cached",
+			wantCache: true,
+		},
+		{
+			name:      "304 with a fresher etag",
+			entry:     &cacheEntry{OG: OG{Title: "Cached"}, ETag: `"v1"`},
+			age:       2 * time.Hour,
+			respETag:  `"v1b"`,
+			wantINM:   `"v1"`,
+			wantHits:  1,
+			wantTitle: "Cached",
+			wantCache: true,
+			wantETag:  `"v1b"`,
+		},
+		{
+			name:      "etag changed",
+			entry:     &cacheEntry{OG: OG{Title: "Cached"}, ETag: `"v0"`},
+			age:       2 * time.Hour,
+			wantINM:   `"v0"`,
+			wantHits:  1,
+			wantTitle: "Fresh",
+			wantETag:  `"v2"`,
+		},
+		{
+			name:      "no validators",
+			entry:     &cacheEntry{OG: OG{Title: "Cached"}},
+			age:       2 * time.Hour,
+			wantHits:  1,
+			wantTitle: "Fresh",
+			wantETag:  `"v2"`,
+		},
+		{
+			name:      "not yet expired",
+			entry:     &cacheEntry{OG: OG{Title: "Cached"}, ETag: `"v1"`},
+			wantTitle: "Cached",
+			wantCache: true,
+			wantETag:  `"v1"`,
+		},
+		{
+			name:      "empty cache",
+			wantHits:  1,
+			wantTitle: "Fresh",
+			wantETag:  `"v2"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int
+			var inm, ims string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits++
+				inm, ims = r.Header.Get("If-None-Match"), r.Header.Get("If-Modified-Since")
+				if inm == `"v1"` || ims == lastMod {
+					if tt.respETag != "" {
+						w.Header().Set("ETag", tt.respETag)
+					}
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("Content-Type", "text/html")
+				w.Header().Set("ETag", `"v2"`)
+				io.WriteString(w, `<meta property="og:title" content="Fresh">`)
+			}))
+			defer srv.Close()
+
+			cache := &ogCache{ttl: time.Hour, entries: map[string]cacheEntry{}}
+			if tt.entry != nil {
+				e := *tt.entry
+				e.FinalURL = srv.URL
+				e.FetchedAt = time.Now().Add(-tt.age)
+				cache.entries[srv.URL] = e
+			}
+			f := newFetcher(time.Second, "")
+			f.cache = cache
+			og, final, st, err := f.fetchOG(context.Background(), srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hits != tt.wantHits {
+				t.Errorf("%d requests, want %d", hits, tt.wantHits)
+			}
+			if inm != tt.wantINM || ims != tt.wantIMS {
+				t.Errorf("If-None-Match %q, If-Modified-Since %q, want %q, %q", inm, ims, tt.wantINM, tt.wantIMS)
+			}
+			if og.Title != tt.wantTitle || final != srv.URL {
+				t.Errorf("fetchOG() = %q, %q, want %q, %q", og.Title, final, tt.wantTitle, srv.URL)
+			}
+			if st.cached != tt.wantCache {
+				t.Errorf("cached = %v, want %v", st.cached, tt.wantCache)
+			}
+			e, ok := cache.get(srv.URL)
+			if !ok {
+				t.Fatal("entry not fresh after the fetch")
+			}
+			if e.OG.Title != tt.wantTitle || e.ETag != tt.wantETag {
+				t.Errorf("cached %q etag %q, want %q etag %q", e.OG.Title, e.ETag, tt.wantTitle, tt.wantETag)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"strings"
+)
+
+//go:embed templates/index.html
+var indexHTML string
+
+var indexTemplate = template.Must(template.New("index").Parse(indexHTML))
+
+type indexEntry struct {
+	Path  string
+	URL   string
+	Title string
+	To    string
+}
+
+// buildIndexHTML renders the -index catalog page linking every generated
+// route by its shop URL. Routes skipped for a dead target are left out.
+func buildIndexHTML(cfg *Config, entries []manifestEntry) (string, error) {
+	var list []indexEntry
+	for _, e := range entries {
+		if e.Title == "" {
+			continue
+		}
+		list = append(list, indexEntry{Path: e.Path, URL: joinURL(cfg.baseURL(), e.Path), Title: e.Title, To: e.To})
+	}
+	var sb strings.Builder
+	if err := indexTemplate.Execute(&sb, list); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
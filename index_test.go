@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildIndexHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		entries []manifestEntry
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "one row per route",
+			cfg:  Config{CNAME: "s.example.com"},
+			entries: []manifestEntry{
+				{Path: "/a", To: "https://shop.example.com/a", Title: "Cap"},
+				{Path: "/b/c", To: "https://shop.example.com/b", Title: "Tom & Jerry"},
+			},
+			want: []string{
+				`<meta name="robots" content="noindex">`,
+				`<tr><td><a href="https://s.example.com/a">/a</a></td><td>Cap<br><small>https://shop.example.com/a</small></td></tr>`,
+				`<tr><td><a href="https://s.example.com/b/c">/b/c</a></td><td>Tom &amp; Jerry<br><small>https://shop.example.com/b</small></td></tr>`,
+			},
+		},
+		{
+			name:    "skipped routes are left out",
+			cfg:     Config{CNAME: "s.example.com"},
+			entries: []manifestEntry{{Path: "/dead", To: "https://shop.example.com/dead"}},
+			notWant: []string{"<tr>"},
+		},
+		{
+			name:    "base path",
+			cfg:     Config{CNAME: "s.example.com", BasePath: "shop"},
+			entries: []manifestEntry{{Path: "/shop/a", To: "https://shop.example.com/a", Title: "Cap"}},
+			want:    []string{`<a href="https://s.example.com/shop/a">/shop/a</a>`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := buildIndexHTML(&tt.cfg, tt.entries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(page, w) {
+					t.Errorf("index does not contain %s", w)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(page, w) {
+					t.Errorf("index contains %s", w)
+				}
+			}
+			if t.Failed() {
+				t.Log(page)
+			}
+		})
+	}
+}
+
+func TestGenerateIndex(t *testing.T) {
+	site := newSite(t, map[string]string{
+		"/p": `<meta property="og:title" content="Cap">`,
+		"/q": `<meta property="og:title" content="Hoodie">`,
+	})
+	tests := []struct {
+		name     string
+		routes   string // %[1]s is the test site's URL
+		wantRows []string
+		wantCode int // 0 for no error
+	}{
+		{
+			name:     "routes",
+			routes:   `{"/a": "%[1]s/p", "/b": "%[1]s/q"}`,
+			wantRows: []string{`>/a</a></td><td>Cap<br>`, `>/b</a></td><td>Hoodie<br>`},
+		},
+		{
+			name:     "root route",
+			routes:   `{"/": "%[1]s/p"}`,
+			wantCode: exitConfig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "routes": `+tt.routes+`}`, site.URL))
+			o.index = true
+			err := generate(context.Background(), o)
+			if tt.wantCode != 0 {
+				if exitCode(err) != tt.wantCode {
+					t.Errorf("generate() = %v with exit code %d, want %d", err, exitCode(err), tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(filepath.Join(o.outDir, "index.html"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := strings.Count(string(b), "<tr>"); n != len(tt.wantRows) {
+				t.Errorf("%d rows, want %d", n, len(tt.wantRows))
+			}
+			for _, w := range tt.wantRows {
+				if !strings.Contains(string(b), w) {
+					t.Errorf("index does not contain %s:\n%s", w, b)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error"}
+
+// logger filters messages below min and, with json set, writes one JSON
+// object per line to stderr instead of the human-readable log format. While
+// bar is drawn on stderr, each line clears it first and redraws it after;
+// mu guards the bar as well as the output.
+var logger = struct {
+	mu   sync.Mutex
+	min  logLevel
+	json bool
+	bar  *progress
+}{min: levelInfo}
+
+func setupLogging(verbose, quiet, jsonLines bool) {
+	switch {
+	case quiet:
+		logger.min = levelWarn
+	case verbose:
+		logger.min = levelDebug
+	}
+	logger.json = jsonLines
+}
+
+func debugf(format string, args ...any) { logf(levelDebug, format, args...) }
+func infof(format string, args ...any)  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...any)  { logf(levelWarn, format, args...) }
+func errorf(format string, args ...any) { logf(levelError, format, args...) }
+
+// exitf logs an error and exits with code, one of the exit* constants.
+func exitf(code int, format string, args ...any) {
+	errorf(format, args...)
+	os.Exit(code)
+}
+
+func logf(level logLevel, format string, args ...any) {
+	if level < logger.min {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if logger.json {
+		writeJSONLog(map[string]any{"level": levelNames[level], "msg": msg})
+		return
+	}
+	switch level {
+	case levelWarn:
+		msg = "warn: " + msg
+	case levelError:
+		msg = "error: " + msg
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if logger.bar != nil {
+		logger.bar.clear()
+		defer logger.bar.draw()
+	}
+	log.Print(msg)
+}
+
+// logRoute reports the outcome of one route's OG fetch.
+func logRoute(j routeJob) {
+	if !logger.json {
+		debugf("fetched %s in %dms", j.to, j.duration.Milliseconds())
+		return
+	}
+	level := levelInfo
+	if j.err != nil {
+		level = levelWarn
+	}
+	if level < logger.min {
+		return
+	}
+	entry := map[string]any{
+		"level":      levelNames[level],
+		"msg":        "route",
+		"path":       publicRoutePath(j.path),
+		"to":         j.to,
+		"ok":         j.err == nil,
+		"durationMs": j.duration.Milliseconds(),
+	}
+	if j.err != nil {
+		entry["error"] = j.err.Error()
+	}
+	writeJSONLog(entry)
+}
+
+func writeJSONLog(entry map[string]any) {
+	entry["time"] = time.Now().Format(time.RFC3339)
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	json.NewEncoder(os.Stderr).Encode(entry)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setLogging applies the -v, -quiet and -log-json flags for the rest of
+// the test.
+func setLogging(t *testing.T, verbose, quiet, jsonLines bool) {
+	t.Helper()
+	saved := logger.min
+	t.Cleanup(func() { logger.min, logger.json = saved, false })
+	logger.min = levelInfo
+	setupLogging(verbose, quiet, jsonLines)
+}
+
+// captureOutput points *f, os.Stdout or os.Stderr, at a temp file for the
+// rest of the test and returns a function reading back what was written.
+func captureOutput(t *testing.T, f **os.File) func() string {
+	t.Helper()
+	tmp, err := os.Create(filepath.Join(t.TempDir(), "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *f
+	*f = tmp
+	t.Cleanup(func() {
+		*f = saved
+		tmp.Close()
+	})
+	return func() string {
+		b, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	tests := []struct {
+		name           string
+		verbose, quiet bool
+		want           []string
+	}{
+		{"default", false, false, []string{"info msg", "warn: warn msg", "error: error msg"}},
+		{"verbose", true, false, []string{"debug msg", "info msg", "warn: warn msg", "error: error msg"}},
+		{"quiet", false, true, []string{"warn: warn msg", "error: error msg"}},
+		{"quiet wins", true, true, []string{"warn: warn msg", "error: error msg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setLogging(t, tt.verbose, tt.quiet, false)
+			logs := captureLog(t)
+			debugf("debug msg")
+			infof("info msg")
+			warnf("warn msg")
+			errorf("error msg")
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+				// drop the date and time the standard logger prefixes
+				if f := strings.SplitN(line, " ", 3); len(f) == 3 {
+					got = append(got, f[2])
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("logged %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONLog(t *testing.T) {
+	tests := []struct {
+		name  string
+		quiet bool
+		log   func()
+		want  []map[string]any // without "time"
+	}{
+		{
+			name: "message",
+			log:  func() { warnf("cache %s is stale", "og-cache.json") },
+			want: []map[string]any{{"level": "warn", "msg": "cache og-cache.json is stale"}},
+		},
+		{
+			name: "route",
+			log: func() {
+				logRoute(routeJob{path: "/a/b", to: "https://shop.example.com/p", duration: 42 * time.Millisecond})
+			},
+			want: []map[string]any{{"level": "info", "msg": "route", "path": "/a/b", "to": "https://shop.example.com/p", "ok": true, "durationMs": 42.0}},
+		},
+		{
+			name: "failed route",
+			log: func() {
+				logRoute(routeJob{path: "", to: "https://shop.example.com/", err: errors.New("timeout"), duration: time.Second})
+			},
+			want: []map[string]any{{"level": "warn", "msg": "route", "path": "/", "to": "https://shop.example.com/", "ok": false, "durationMs": 1000.0, "error": "timeout"}},
+		},
+		{
+			name:  "quiet drops ok routes",
+			quiet: true,
+			log: func() {
+				logRoute(routeJob{path: "/a", to: "https://shop.example.com/a"})
+				infof("done")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setLogging(t, false, tt.quiet, true)
+			stderr := captureOutput(t, &os.Stderr)
+			tt.log()
+			var got []map[string]any
+			sc := bufio.NewScanner(strings.NewReader(stderr()))
+			for sc.Scan() {
+				var entry map[string]any
+				if err := json.Unmarshal(sc.Bytes(), &entry); err != nil {
+					t.Fatalf("%q is not JSON: %v", sc.Text(), err)
+				}
+				if _, err := time.Parse(time.RFC3339, entry["time"].(string)); err != nil {
+					t.Errorf("time: %v", err)
+				}
+				delete(entry, "time")
+				got = append(got, entry)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("logged %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,178 +2,956 @@ package main
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	htmlstd "html"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	xhtml "golang.org/x/net/html"
+	qrcode "github.com/skip2/go-qrcode"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	CNAME           string            `json:"cname"`
 	GlobalOG        string            `json:"globalOG"`
 	DefaultRedirect string            `json:"defaultRedirect"`
-	Routes          map[string]string `json:"routes"`
+	Routes          map[string]Route  `json:"routes"`
+	Robots          string            `json:"robots,omitempty"`
+	BaseURL         string            `json:"baseURL,omitempty"` // overridden by -base-url
+	BasePath        string            `json:"basePath,omitempty"`
+	RedirectDelay   int               `json:"redirectDelay,omitempty"` // seconds; 0 redirects instantly
+	Analytics       Analytics         `json:"analytics"`
+	AllowedHosts    []string          `json:"allowedHosts,omitempty"`   // "example.com" or ".example.com" for it and its subdomains
+	DefaultUTM      map[string]string `json:"defaultUTM,omitempty"`     // query params added to every target, e.g. utm_source
+	AppleTouchIcon  string            `json:"appleTouchIcon,omitempty"` // defaults to the target's own
+	ThemeColor      string            `json:"themeColor,omitempty"`     // defaults to the target's own
+	Locale          string            `json:"locale,omitempty"`         // og:locale unless the target declares one; default ko_KR
+	Brand           Brand             `json:"brand"`
+	// HeadInclude and BodyInclude are raw HTML inserted into every page's
+	// <head> and before </body>, e.g. a verification tag or consent banner.
+	// They are not escaped, so only put trusted markup there; BodyText is
+	// the escaped alternative for plain text. ${VAR} is not expanded in
+	// them, so a JavaScript template literal survives as written.
+	HeadInclude string `json:"headInclude,omitempty"`
+	BodyInclude string `json:"bodyInclude,omitempty"`
+	BodyText    string `json:"bodyText,omitempty"`
+	// CanonicalSlash is the trailing-slash policy of canonical, og:url and
+	// sitemap URLs: "strip" (the default), "add", or "preserve" to follow
+	// each route key. Output files are laid out the same either way.
+	CanonicalSlash string `json:"canonicalSlash,omitempty"`
+	// AlternateToTarget adds <link rel="alternate"> to each route's target
+	// next to the canonical shop URL.
+	AlternateToTarget bool `json:"alternateToTarget,omitempty"`
+	// PWA is set for runs with -manifest-pwa, whose pages link manifest.json.
+	PWA *PWA `json:"pwa,omitempty"`
 }
 
-type OG struct {
-	Title       string
-	Description string
-	Image       string
+// Brand styles the loading screen shown while a page redirects. Nothing is
+// shown when every field is empty.
+type Brand struct {
+	Logo   string `json:"logo,omitempty"`   // image URL shown above the spinner
+	Accent string `json:"accent,omitempty"` // CSS color of the spinner, e.g. #ff5a00
+	Text   string `json:"text,omitempty"`   // defaults to "이동 중이에요…"
+}
+
+// Analytics configures page-view tracking on generated pages. The page view
+// is sent before the redirect fires.
+type Analytics struct {
+	GA4       string `json:"ga4,omitempty"`       // GA4 measurement ID, e.g. G-XXXXXXX
+	Plausible string `json:"plausible,omitempty"` // Plausible site domain
+}
+
+// Route is a routes.json entry. It unmarshals from either a plain target URL
+// string or an object whose OG fields override the fetched values.
+type Route struct {
+	To          string `json:"to"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	// Index makes the page indexable with its canonical pointing at the
+	// target; by default pages are noindex and canonical to the shop URL.
+	Index bool `json:"index,omitempty"`
+	// Disabled keeps the route in the config without generating it.
+	Disabled bool `json:"disabled,omitempty"`
+	// Robots replaces the robots meta content, e.g. "noindex, nofollow".
+	// The default is "noindex", or no tag at all with Index set.
+	Robots string `json:"robots,omitempty"`
+	// UTM params for this route; they win over DefaultUTM and the target's
+	// own query.
+	UTM map[string]string `json:"utm,omitempty"`
+	// Variants split visitors between several destinations by weight; each
+	// browser sticks to its first pick. To stays the target for crawlers,
+	// the canonical and visitors without JavaScript.
+	Variants []Variant `json:"variants,omitempty"`
+
+	slash bool // the route key ends in "/", for CanonicalSlash "preserve"
+}
+
+// Variant is one A/B destination of a route. A zero weight counts as 1.
+type Variant struct {
+	To     string  `json:"to"`
+	Weight float64 `json:"weight,omitempty"`
+}
+
+func (r *Route) UnmarshalJSON(b []byte) error {
+	var to string
+	if err := json.Unmarshal(b, &to); err == nil {
+		*r = Route{To: to}
+		return nil
+	}
+	type plain Route
+	return json.Unmarshal(b, (*plain)(r))
+}
+
+// applyOverrides replaces fetched OG values with any set on the route.
+func (r Route) applyOverrides(og OG) OG {
+	if r.Title != "" {
+		og.Title = r.Title
+	}
+	if r.Description != "" {
+		og.Description = r.Description
+	}
+	if r.Image != "" && r.Image != og.Image {
+		og.Image = r.Image
+		og.ImageWidth, og.ImageHeight, og.ImageAlt = 0, 0, ""
+	}
+	return og
+}
+
+// withUTM adds DefaultUTM and r.UTM to the query of to. DefaultUTM never
+// replaces a parameter the target already carries; the route's own UTM does.
+// The rest of the query is kept byte for byte, as signed or affiliate links
+// may depend on its order and encoding, and missing pairs are appended.
+func (c *Config) withUTM(r Route, to string) string {
+	if len(c.DefaultUTM) == 0 && len(r.UTM) == 0 {
+		return to
+	}
+	rest, frag, hasFrag := strings.Cut(to, "#")
+	rest, query, _ := strings.Cut(rest, "?")
+	var pairs []string
+	if query != "" {
+		pairs = strings.Split(query, "&")
+	}
+	has := map[string]bool{}
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		k, _, _ := strings.Cut(pair, "=")
+		if key, err := url.QueryUnescape(k); err == nil {
+			k = key
+		}
+		v, ok := r.UTM[k]
+		switch {
+		case !ok:
+			kept = append(kept, pair)
+		case !has[k]:
+			kept = append(kept, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+		has[k] = true
+	}
+	add := map[string]string{}
+	for k, v := range c.DefaultUTM {
+		if !has[k] {
+			add[k] = v
+		}
+	}
+	for k, v := range r.UTM {
+		if !has[k] {
+			add[k] = v
+		}
+	}
+	for _, k := range sortedKeys(add) {
+		kept = append(kept, url.QueryEscape(k)+"="+url.QueryEscape(add[k]))
+	}
+	to = rest
+	if len(kept) > 0 {
+		to += "?" + strings.Join(kept, "&")
+	}
+	if hasFrag {
+		to += "#" + frag
+	}
+	return to
+}
+
+// baseURL returns the public origin of the generated site without a trailing
+// slash: BaseURL when set, otherwise https:// plus CNAME. -base-url replaces
+// BaseURL after loading, so it wins over both.
+func (c *Config) baseURL() string {
+	if b := strings.TrimSpace(c.BaseURL); b != "" {
+		return strings.TrimRight(b, "/")
+	}
+	if c.CNAME != "" {
+		return "https://" + c.CNAME
+	}
+	return ""
+}
+
+// globalOG is GlobalOG made absolute against the site's base URL, as a
+// relative globalOG names a file of the generated site rather than of the
+// route's target.
+func (c *Config) globalOG() string {
+	g := strings.TrimSpace(c.GlobalOG)
+	if g == "" {
+		return ""
+	}
+	if abs, err := absolutize(g, c.baseURL()+"/"); err == nil {
+		return encodeURL(abs)
+	}
+	return g
+}
+
+// pageURL is the public URL of the page for r at path, with a trailing slash
+// according to CanonicalSlash.
+func (c *Config) pageURL(path string, r Route) string {
+	u := joinURL(c.baseURL(), path)
+	if strings.HasSuffix(u, "/") {
+		return u
+	}
+	if c.CanonicalSlash == "add" || (c.CanonicalSlash == "preserve" && r.slash) {
+		return u + "/"
+	}
+	return u
+}
+
+// routePath normalizes a route key and prefixes it with BasePath, so both the
+// output directory and the public URL live under the subpath.
+func (c *Config) routePath(p string) string {
+	bp := strings.Trim(strings.TrimSpace(c.BasePath), "/")
+	if bp == "" {
+		return cleanRoutePath(p)
+	}
+	return cleanRoutePath("/" + bp + cleanRoutePath(p))
+}
+
+// options holds the command-line flags.
+type options struct {
+	cfgPath, outDir      string
+	skipInvalid          bool
+	allowEmpty           bool
+	mergeDuplicates      bool
+	concurrency          int
+	cachePath            string
+	cacheTTL             time.Duration
+	sitemap              bool
+	index                bool
+	format               string
+	failOnDead           bool
+	resolveRedirects     bool
+	retries              int
+	rate                 float64
+	maxBody              int64
+	timeout              time.Duration
+	deadline             time.Duration
+	userAgent            string
+	dryRun               bool
+	diff                 bool
+	validate             bool
+	mirrorImages         bool
+	mirrorMaxBytes       int64
+	altFromTitle         bool
+	verifyImages         bool
+	maxDescription       int
+	pageBudget           int
+	strict               bool
+	manifestPWA          bool
+	baseURL              string
+	upgradeImages        bool
+	minify               bool
+	precompress          string
+	qr                   bool
+	qrModule             int
+	manifestPath         string
+	reportPath           string
+	check                bool
+	pruneCache           bool
+	failOnOGError        bool
+	templatePath         string
+	notFoundTemplatePath string
+	noJekyll             bool
+	htmlSiblings         bool
+	clean                bool
+	watch                bool
+	strictEnv            bool
+	single               string
+	serve                string
+	verbose, quiet       bool
+	logJSON              bool
 }
 
 func main() {
-	var cfgPath, outDir string
-	flag.StringVar(&cfgPath, "config", "routes.json", "path to routes.json")
-	flag.StringVar(&outDir, "out", ".", "output directory")
+	var o options
+	flag.StringVar(&o.cfgPath, "config", "routes.json", "path to routes.json or a .yaml/.yml config, \"-\" for JSON on stdin, or an http(s) URL")
+	flag.StringVar(&o.outDir, "out", ".", "output directory")
+	flag.BoolVar(&o.strictEnv, "strict-env", false, "fail when the config references an unset ${VAR} without a default")
+	flag.BoolVar(&o.allowEmpty, "allow-empty", false, "allow a config without routes, e.g. to only write CNAME and 404.html")
+	flag.BoolVar(&o.skipInvalid, "skip-invalid", false, "skip routes with non-http(s) targets instead of failing")
+	flag.BoolVar(&o.mergeDuplicates, "merge-duplicates", false, "merge route keys that map to the same path (last in sorted order wins) instead of failing")
+	flag.IntVar(&o.concurrency, "concurrency", 8, "number of concurrent OG fetches")
+	flag.StringVar(&o.cachePath, "cache", "", "path to an OG cache file (disabled when empty)")
+	flag.DurationVar(&o.cacheTTL, "cache-ttl", 24*time.Hour, "how long cached OG data stays fresh (0 = forever)")
+	flag.BoolVar(&o.sitemap, "sitemap", false, "write sitemap.xml listing all routes")
+	flag.BoolVar(&o.failOnDead, "fail-on-dead", false, "skip routes whose target responds with a non-2xx status")
+	flag.BoolVar(&o.resolveRedirects, "resolve-redirects", false, "redirect straight to the final URL of a target's redirect chain")
+	flag.IntVar(&o.retries, "retries", 2, "retry attempts for failed OG fetches")
+	flag.Float64Var(&o.rate, "rate", 0, "max OG requests per second to any single host (0 = unlimited)")
+	flag.Int64Var(&o.maxBody, "max-body", defaultMaxBody, "read at most this many bytes of each target page")
+	flag.DurationVar(&o.deadline, "deadline", 0, "stop fetching after this long and write what completed (0 = no limit)")
+	flag.DurationVar(&o.timeout, "timeout", defaultTimeout, "HTTP timeout per OG fetch")
+	flag.StringVar(&o.userAgent, "user-agent", defaultUserAgent, "User-Agent header sent when fetching targets")
+	flag.StringVar(&o.format, "format", "html", "output format: html, netlify or vercel")
+	flag.BoolVar(&o.dryRun, "dry-run", false, "fetch and render everything but only log the files that would be written")
+	flag.BoolVar(&o.diff, "diff", false, "print a unified diff of what generating would change, without writing")
+	flag.BoolVar(&o.mirrorImages, "mirror-images", false, "download OG images into the output and serve them from /_og/")
+	flag.Int64Var(&o.mirrorMaxBytes, "mirror-max-bytes", 5<<20, "skip mirroring images larger than this")
+	flag.IntVar(&o.maxDescription, "max-description", 200, "truncate descriptions to this many characters (0 = no limit)")
+	flag.IntVar(&o.pageBudget, "page-budget", 16<<10, "warn when a route page is larger than this many bytes (0 = no limit)")
+	flag.BoolVar(&o.strict, "strict", false, "fail instead of warning when a generated page breaks a check such as -page-budget")
+	flag.BoolVar(&o.verifyImages, "verify-images", false, "check each og:image with a HEAD request and fall back to globalOG when it is dead or not an image")
+	flag.StringVar(&o.precompress, "precompress", "", "also write compressed .html siblings for static hosts: gzip, br or gzip,br")
+	flag.BoolVar(&o.minify, "minify", false, "strip comments and insignificant whitespace from generated pages")
+	flag.BoolVar(&o.upgradeImages, "upgrade-insecure-images", false, "rewrite http:// og:image URLs to https:// when the site is served over https, instead of only warning")
+	flag.BoolVar(&o.altFromTitle, "alt-from-title", false, "use the title as og:image:alt when the target has no image alt text")
+	flag.BoolVar(&o.qr, "qr", false, "write a qr.png encoding the public URL into each route directory")
+	flag.IntVar(&o.qrModule, "qr-module", 8, "QR code module size in pixels")
+	flag.StringVar(&o.baseURL, "base-url", "", "public origin for canonical and og:url, overriding the config's baseURL and CNAME, e.g. for preview deploys")
+	flag.BoolVar(&o.manifestPWA, "manifest-pwa", false, "write a web app manifest.json from the config's pwa section and link it from every page")
+	flag.StringVar(&o.manifestPath, "manifest", "", "write a JSON manifest of generated routes to this path")
+	flag.StringVar(&o.reportPath, "report", "", "write per-route fetch diagnostics (timing, size, status, fallbacks) as JSON to this path")
+	flag.BoolVar(&o.check, "check", false, "only check that every target responds and exit non-zero on dead links")
+	flag.BoolVar(&o.failOnOGError, "fail-on-og-error", false, "exit non-zero if any OG fetch failed")
+	flag.StringVar(&o.templatePath, "template", "", "page template file (html/template) replacing the embedded default")
+	flag.StringVar(&o.notFoundTemplatePath, "404-template", "", "404.html template (html/template) replacing the embedded default")
+	flag.BoolVar(&o.noJekyll, "nojekyll", true, "write .nojekyll so GitHub Pages serves underscore paths like /_og/")
+	flag.BoolVar(&o.htmlSiblings, "html-siblings", false, "also write <route>.html next to <route>/index.html")
+	flag.BoolVar(&o.clean, "clean", false, "remove files the previous run wrote that this run does not, once it has succeeded")
+	flag.BoolVar(&o.index, "index", false, "write a noindex index.html at the site root listing every route")
+	flag.BoolVar(&o.pruneCache, "prune-cache", false, "only drop -cache entries for targets the config no longer references and exit")
+	flag.BoolVar(&o.validate, "validate", false, "only check the config for problems and exit")
+	flag.StringVar(&o.single, "single", "", "regenerate only this route path, leaving all other output untouched")
+	flag.BoolVar(&o.watch, "watch", false, "regenerate whenever the config or template file changes")
+	flag.StringVar(&o.serve, "serve", "", "serve real 302 redirects on this address (e.g. :8080) instead of generating files")
+	flag.BoolVar(&o.verbose, "v", false, "verbose logging")
+	flag.BoolVar(&o.quiet, "quiet", false, "only log warnings and errors")
+	flag.BoolVar(&o.logJSON, "log-json", false, "log JSON lines, one per route plus one per message")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), exitCodesHelp)
+	}
 	flag.Parse()
+	setupLogging(o.verbose, o.quiet, o.logJSON)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch o.format {
+	case "html", "netlify", "vercel":
+	default:
+		exitf(exitConfig, "unknown -format %q", o.format)
+	}
+
+	if o.baseURL != "" {
+		if err := validateTarget(o.baseURL); err != nil {
+			exitf(exitConfig, "-base-url: %v", err)
+		}
+	}
+
+	if o.cfgPath == "-" && (o.watch || o.serve != "") {
+		exitf(exitConfig, "-watch and -serve reload the config, so it cannot come from stdin")
+	}
+
+	if o.single != "" && (o.format != "html" || o.clean) {
+		exitf(exitConfig, "-single needs -format html and cannot be combined with -clean")
+	}
+
+	if o.validate {
+		cfg, err := loadConfig(o.cfgPath, o.strictEnv)
+		must(err)
+		if o.baseURL != "" {
+			cfg.BaseURL = o.baseURL
+		}
+		errs := validateConfig(cfg, o.allowEmpty)
+		for _, err := range errs {
+			errorf("invalid: %v", err)
+		}
+		if len(errs) > 0 {
+			exitf(exitConfig, "%s: %d problem(s) found", o.cfgPath, len(errs))
+		}
+		infof("%s: ok (%d routes)", o.cfgPath, len(cfg.Routes))
+		return
+	}
+
+	if o.pruneCache {
+		if o.cachePath == "" {
+			exitf(exitConfig, "-prune-cache needs -cache")
+		}
+		cfg, err := loadConfig(o.cfgPath, o.strictEnv)
+		must(err)
+		cache, err := loadCache(o.cachePath, o.cacheTTL)
+		must(err)
+		n := cache.prune(cfg.fetchTargets())
+		must(withCode(exitWrite, cache.save()))
+		infof("%s: pruned %d entries, %d left", o.cachePath, n, len(cache.entries))
+		return
+	}
+
+	if o.check {
+		cfg, err := loadConfig(o.cfgPath, o.strictEnv)
+		must(err)
+		if dead := runCheck(cfg, newFetcher(o.timeout, o.userAgent), o.concurrency); dead > 0 {
+			os.Exit(exitFetch)
+		}
+		return
+	}
+
+	if o.serve != "" {
+		must(serve(ctx, &o))
+		return
+	}
+	if o.watch {
+		must(watch(ctx, &o))
+		return
+	}
+	must(generate(ctx, &o))
+}
+
+// generate runs the pipeline once: load the config, fetch OG data for every
+// route and write the output. When ctx is cancelled or -deadline passes, the
+// routes fetched so far are still written and the rest are left as they were.
+func generate(ctx context.Context, o *options) error {
+	if o.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.deadline)
+		defer cancel()
+	}
+	cfg, err := loadConfig(o.cfgPath, o.strictEnv)
+	if err != nil {
+		return err
+	}
+	if o.baseURL != "" {
+		cfg.BaseURL = o.baseURL
+	}
+	switch {
+	case !o.manifestPWA:
+		cfg.PWA = nil
+	case cfg.PWA == nil:
+		cfg.PWA = &PWA{}
+	}
+	pageTmpl, err := loadPageTemplate(o.templatePath, defaultPage)
+	if err != nil {
+		return withCode(exitConfig, err)
+	}
+	notFoundTmpl, err := loadPageTemplate(o.notFoundTemplatePath, default404)
+	if err != nil {
+		return withCode(exitConfig, err)
+	}
+
+	if err := cfg.checkConfig(o); err != nil {
+		return err
+	}
+
+	if o.index {
+		for _, p := range cfg.pageRoutes() {
+			if cfg.pagePath(p) == cfg.routePath("/") {
+				return withCode(exitConfig, fmt.Errorf("-index: route %q already uses the root index.html", p))
+			}
+		}
+	}
+
+	// -single leaves every file but the route's own untouched
+	siteWide := o.single == ""
+	if !siteWide {
+		key, ok := "", false
+		for _, p := range sortedRoutes(cfg) {
+			if cleanRoutePath(p) == cleanRoutePath(o.single) {
+				key, ok = p, true
+			}
+		}
+		if !ok {
+			return withCode(exitConfig, fmt.Errorf("-single: route %s not found in %s", cleanRoutePath(o.single), o.cfgPath))
+		}
+		cfg.Routes = map[string]Route{key: cfg.Routes[key]}
+	}
+
+	var cache *ogCache
+	if o.cachePath != "" {
+		if cache, err = loadCache(o.cachePath, o.cacheTTL); err != nil {
+			return err
+		}
+	}
+
+	if err := cfg.dropInvalid(o); err != nil {
+		return err
+	}
+
+	precompress, err := parsePrecompress(o.precompress)
+	if err != nil {
+		return withCode(exitConfig, err)
+	}
+	out := &output{dir: o.outDir, dryRun: o.dryRun || o.diff, diff: o.diff, precompress: precompress}
+	// fail before any fetching when nothing could be written anyway
+	if err := out.prepare(); err != nil {
+		return withCode(exitWrite, err)
+	}
+
+	if o.noJekyll && o.format == "html" && siteWide {
+		if err := out.write(".nojekyll", nil); err != nil {
+			return err
+		}
+	}
+	if cfg.CNAME != "" && siteWide {
+		if err := out.write("CNAME", []byte(cfg.CNAME+"\n")); err != nil {
+			return err
+		}
+	}
 
-	cfg, err := loadConfig(cfgPath)
-	must(err)
+	// sorted so logs and the manifest come out in the same order every run
+	jobs := make([]routeJob, 0, len(cfg.Routes))
+	for _, p := range cfg.pageRoutes() {
+		r := cfg.Routes[p]
+		jobs = append(jobs, routeJob{path: cfg.pagePath(p), to: r.To, route: r})
+	}
+	for _, j := range jobs {
+		infof("fetching OG: %s -> %s", j.path, j.to)
+	}
+	f := newFetcher(o.timeout, o.userAgent)
+	f.cache, f.retries, f.limit = cache, o.retries, newHostLimiter(o.rate)
+	if o.maxBody > 0 {
+		f.maxBody = o.maxBody
+	}
+	f.progress = newProgress(len(jobs))
+	f.fetchAll(ctx, jobs, o.concurrency)
+	f.progress.finish()
+	f.progress = nil
+	for _, j := range jobs {
+		logRoute(j)
+	}
 
-	// ensure output directory exists
-	if err := os.MkdirAll(outDir, 0o755); err != nil { log.Fatal(err) }
+	var mirror *imageMirror
+	if o.mirrorImages {
+		mirror = &imageMirror{ctx: ctx, f: f, out: out, cfg: cfg, maxBytes: o.mirrorMaxBytes, done: map[string]mirroredImage{}}
+	}
 
-	if strings.TrimSpace(cfg.CNAME) != "" {
-		must(os.WriteFile(filepath.Join(outDir, "CNAME"), []byte(cfg.CNAME+"\n"), 0644))
+	ro := renderOptions{options: o, mirror: mirror}
+	if o.verifyImages {
+		ro.verifier = newImageVerifier(ctx, f)
+	}
+	if o.format == "html" {
+		ro.tmpl = pageTmpl
 	}
 
-	for p, to := range cfg.Routes {
-		routePath := cleanRoutePath(p)
-		log.Printf("fetching OG: %s -> %s", routePath, to)
-		og, err := fetchOG(to)
+	var manifest []manifestEntry
+	var report []reportEntry
+	ogFailed, interrupted := 0, 0
+	for _, j := range jobs {
+		if j.err != nil {
+			ogFailed++
+		}
+		report = append(report, newReportEntry(j))
+		routePath, to := j.path, j.to
+		entry := manifestEntry{Path: publicRoutePath(routePath), To: to, FetchedAt: j.fetchedAt, OK: j.err == nil}
+		if j.err != nil && ctx.Err() != nil && errors.Is(j.err, ctx.Err()) {
+			debugf("skipping %s: %v", routePath, j.err)
+			interrupted++
+			manifest = append(manifest, entry)
+			continue
+		}
+		var se *statusError
+		if errors.As(j.err, &se) && o.failOnDead {
+			warnf("skipping %s: dead target: %v", routePath, se)
+			manifest = append(manifest, entry)
+			continue
+		}
+		if j.err != nil {
+			warnf("OG fetch failed for %s: %v (using fallbacks)", to, j.err)
+		}
+		report[len(report)-1].Fallbacks = missingFields(j.route.applyOverrides(j.og))
+		rendered, err := renderRoute(cfg, j, ro)
 		if err != nil {
-			log.Printf("warn: OG fetch failed for %s: %v (using fallbacks)", to, err)
+			return err
+		}
+		to, og := rendered.to, rendered.og
+
+		entry.To, entry.Title, entry.Description, entry.Image = to, og.Title, og.Description, og.Image
+		manifest = append(manifest, entry)
+
+		if o.format != "html" {
+			continue
 		}
-		if og.Image == "" && cfg.GlobalOG != "" {
-			og.Image = cfg.GlobalOG
+		if o.dryRun && !o.diff {
+			infof("dry-run: %s -> %s title=%q description=%q image=%q", routePath, to, og.Title, og.Description, og.Image)
 		}
-		if og.Title == "" {
-			og.Title = "UniGoods"
+		dir := strings.TrimPrefix(routePath, "/")
+		if err := out.write(filepath.Join(dir, "index.html"), []byte(rendered.html)); err != nil {
+			return err
 		}
-		if og.Description == "" {
-			og.Description = "UniGoods link"
+		// GitHub Pages serves /a/b from a/b.html, so /a/b and /a/b/ both work
+		if o.htmlSiblings && routePath != "" {
+			if err := out.write(dir+".html", []byte(rendered.html)); err != nil {
+				return err
+			}
+		}
+		if o.qr {
+			png, err := qrcode.Encode(cfg.pageURL(routePath, j.route), qrcode.Medium, -o.qrModule)
+			if err != nil {
+				return fmt.Errorf("route %s: %w", routePath, err)
+			}
+			if err := out.write(filepath.Join(dir, "qr.png"), png); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch {
+	case !siteWide:
+	case o.format == "netlify":
+		if err := out.write("_redirects", []byte(buildNetlifyRedirects(cfg))); err != nil {
+			return err
+		}
+	case o.format == "vercel":
+		var keep []string
+		if o.sitemap {
+			keep = append(keep, "/sitemap.xml")
 		}
-		if og.Image != "" {
-			if abs, err := absolutize(og.Image, to); err == nil {
-				og.Image = abs
+		if buildRobots(cfg, o.sitemap) != "" {
+			keep = append(keep, "/robots.txt")
+		}
+		if o.manifestPWA {
+			keep = append(keep, cfg.webManifestPath())
+		}
+		if o.index {
+			keep = append(keep, publicPath(cfg, "/"))
+		}
+		vercel, err := buildVercelConfig(cfg, keep)
+		if err != nil {
+			return err
+		}
+		if err := out.write("vercel.json", []byte(vercel)); err != nil {
+			return err
+		}
+	case strings.TrimSpace(cfg.DefaultRedirect) != "" || len(cfg.wildcards()) > 0:
+		var og OG
+		if to := strings.TrimSpace(cfg.DefaultRedirect); to != "" {
+			infof("fetching OG: 404 -> %s", to)
+			fetched, final, _, err := f.fetchOG(ctx, to)
+			if err != nil {
+				warnf("OG fetch failed for %s: %v (using fallbacks)", to, err)
+			} else {
+				og = fetched
+				// globalOG goes before the last-resort images, like resolveOG
+				if og.Image == "" && cfg.globalOG() == "" {
+					og.Image = og.fallbackImage()
+				}
+				if og.Image != "" {
+					if abs, err := absolutize(og.Image, final); err == nil {
+						og.Image = encodeURL(abs)
+					}
+				}
 			}
 		}
+		og.Title = cmp.Or(og.Title, "UniGoods")
+		og.Description = truncateRunes(cmp.Or(og.Description, "유니굿즈 숍으로 이동합니다."), o.maxDescription)
+		og.Image = cmp.Or(og.Image, cfg.globalOG())
+		page, err := build404HTML(notFoundTmpl, cfg, og)
+		if err != nil {
+			return fmt.Errorf("404 page: %w", err)
+		}
+		if o.minify {
+			page = minifyHTML(page)
+		}
+		if err := out.write("404.html", []byte(page)); err != nil {
+			return err
+		}
+	}
 
-		destDir := filepath.Join(outDir, strings.TrimPrefix(routePath, "/"))
-		must(os.MkdirAll(destDir, 0755))
-		htmlPage := buildHTML(routePath, to, og)
-		must(os.WriteFile(filepath.Join(destDir, "index.html"), []byte(htmlPage), 0644))
+	if o.sitemap && siteWide {
+		if err := out.write("sitemap.xml", []byte(buildSitemap(cfg, cache))); err != nil {
+			return err
+		}
+	}
+	if o.manifestPWA && siteWide {
+		m, err := buildWebManifest(cfg)
+		if err != nil {
+			return fmt.Errorf("web app manifest: %w", err)
+		}
+		if err := out.write(strings.TrimPrefix(cfg.webManifestPath(), "/"), []byte(m)); err != nil {
+			return err
+		}
+	}
+	if o.index && siteWide {
+		page, err := buildIndexHTML(cfg, manifest)
+		if err != nil {
+			return fmt.Errorf("index page: %w", err)
+		}
+		if err := out.write(filepath.Join(strings.TrimPrefix(cfg.routePath("/"), "/"), "index.html"), []byte(page)); err != nil {
+			return err
+		}
+	}
+	if robots := buildRobots(cfg, o.sitemap); siteWide && robots != "" {
+		if err := out.write("robots.txt", []byte(robots)); err != nil {
+			return err
+		}
 	}
 
-	if strings.TrimSpace(cfg.DefaultRedirect) != "" {
-		og := OG{
-			Title:       "UniGoods",
-			Description: "유니굿즈 숍으로 이동합니다.",
-			Image:       cfg.GlobalOG,
+	// only a complete run knows which of the previous files are stale
+	if o.clean && interrupted == 0 {
+		if err := out.clean(); err != nil {
+			return err
+		}
+	}
+	if err := out.writeMarker(); err != nil {
+		return err
+	}
+	if out.diff {
+		infof("%d file(s) differ", out.printDiff(os.Stdout))
+	}
+	if !out.dryRun {
+		if err := cache.save(); err != nil {
+			return withCode(exitWrite, err)
+		}
+		if o.manifestPath != "" && siteWide {
+			if err := writeManifest(o.manifestPath, manifest); err != nil {
+				return withCode(exitWrite, err)
+			}
+		}
+		if o.reportPath != "" {
+			if err := writeReport(o.reportPath, report); err != nil {
+				return withCode(exitWrite, err)
+			}
 		}
-		page := buildHTML("/404", cfg.DefaultRedirect, og)
-		must(os.WriteFile(filepath.Join(outDir, "404.html"), []byte(page), 0644))
 	}
 
-	log.Println("✅ done.")
+	infof("summary: %d routes, %d OG ok, %d OG failed (using fallbacks), %d files written, %d unchanged",
+		len(jobs), len(jobs)-ogFailed, ogFailed, out.written, out.unchanged)
+	if interrupted > 0 {
+		return fmt.Errorf("%v: %d route(s) not generated", ctx.Err(), interrupted)
+	}
+	if o.failOnOGError && ogFailed > 0 {
+		return withCode(exitFetch, fmt.Errorf("%d OG fetch(es) failed", ogFailed))
+	}
+	infof("✅ done.")
+	return nil
 }
 
-func loadConfig(path string) (*Config, error) {
-	b, err := os.ReadFile(path)
+// loadConfig reads a JSON or YAML config and expands ${VAR} references in
+// its string values. Route keys starting with "//" are comments and disabled
+// routes are dropped, so neither is generated; -clean then removes their
+// earlier output.
+func loadConfig(path string, strictEnv bool) (_ *Config, err error) {
+	defer func() { err = withCode(exitConfig, err) }()
+	b, ext, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	switch ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &v)
+	default:
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&v)
+	}
 	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	switch top := v.(type) {
+	case nil:
+		// an empty file; the routes check reports it
+	case map[string]any:
+		// before anything else looks at them, as comments may hold any value
+		if routes, ok := top["routes"].(map[string]any); ok {
+			for k := range routes {
+				if strings.HasPrefix(k, "//") {
+					delete(routes, k)
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%s: the config must be an object, not %T", path, v)
+	}
+	if v, err = expandEnv(v, strictEnv); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if b, err = json.Marshal(v); err != nil {
 		return nil, err
 	}
 	var c Config
 	if err := json.Unmarshal(b, &c); err != nil {
 		return nil, err
 	}
+	if c.CNAME, err = normalizeCNAME(c.CNAME); err != nil {
+		return nil, err
+	}
+	switch c.CanonicalSlash {
+	case "", "strip", "add", "preserve":
+	default:
+		return nil, fmt.Errorf("%s: canonicalSlash %q must be strip, add or preserve", path, c.CanonicalSlash)
+	}
+	for _, p := range sortedRoutes(&c) {
+		r := c.Routes[p]
+		if r.Disabled {
+			infof("skipping disabled route %s", p)
+			delete(c.Routes, p)
+			continue
+		}
+		r.slash = strings.HasSuffix(p, "/")
+		c.Routes[p] = r
+	}
 	return &c, nil
 }
 
-func cleanRoutePath(p string) string {
-	if p == "" {
-		return "/"
+// readConfig returns the raw config at path along with the lowercase
+// extension that picks its format. path is a file, "-" for JSON on stdin, or
+// an http(s) URL.
+func readConfig(path string) ([]byte, string, error) {
+	if path == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		return b, ".json", err
 	}
-	if !strings.HasPrefix(p, "/") {
-		p = "/" + p
+	if !isRemoteConfig(path) {
+		b, err := os.ReadFile(path)
+		return b, strings.ToLower(filepath.Ext(path)), err
 	}
-	return strings.TrimSuffix(p, "/")
-}
-
-func fetchOG(target string) (OG, error) {
-	client := &http.Client{Timeout: 12 * time.Second}
-	req, err := http.NewRequest("GET", target, nil)
+	u, err := url.Parse(path)
 	if err != nil {
-		return OG{}, err
+		return nil, "", err
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-	req.Header.Set("Accept", "text/html")
-	req.Header.Set("Accept-Language", "ko-KR,ko;q=0.9,en-US;q=0.8,en;q=0.7")
-
-	res, err := client.Do(req)
+	res, err := (&http.Client{Timeout: defaultTimeout}).Get(path)
 	if err != nil {
-		return OG{}, err
+		return nil, "", err
 	}
 	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, "", &statusError{Code: res.StatusCode, URL: path}
+	}
+	b, err := io.ReadAll(res.Body)
+	return b, strings.ToLower(filepath.Ext(u.Path)), err
+}
 
-	body, err := io.ReadAll(io.LimitReader(res.Body, 2<<20))
-	if err != nil {
-		return OG{}, err
+// isRemoteConfig reports whether path names a config to fetch over HTTP
+// rather than a file.
+func isRemoteConfig(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// normalizeCNAME reduces a CNAME value such as "https://Shop.Example.com/" to
+// the bare lowercase host GitHub Pages expects.
+func normalizeCNAME(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+3:]
+	}
+	if i := strings.IndexAny(s, "/?#"); i >= 0 {
+		s = s[:i]
 	}
-	return parseOGHTML(body, target), nil
+	s = strings.ToLower(strings.TrimSuffix(s, "."))
+	if strings.ContainsAny(s, " \t,@:") {
+		return "", fmt.Errorf("cname %q is not a single host name", raw)
+	}
+	return s, nil
 }
 
-func parseOGHTML(body []byte, base string) OG {
-	doc, err := xhtml.Parse(bytes.NewReader(body))
-	if err != nil {
-		return OG{}
-	}
-	var og OG
-	var f func(*xhtml.Node)
-	f = func(n *xhtml.Node) {
-		if n.Type == xhtml.ElementNode && strings.EqualFold(n.Data, "meta") {
-			var prop, name, cont string
-			for _, a := range n.Attr {
-				switch strings.ToLower(a.Key) {
-				case "property":
-					prop = strings.ToLower(strings.TrimSpace(a.Val))
-				case "name":
-					name = strings.ToLower(strings.TrimSpace(a.Val))
-				case "content":
-					cont = strings.TrimSpace(a.Val)
-				}
-			}
-			key := prop
-			if key == "" {
-				key = name
-			}
-			switch key {
-			case "og:title":
-				og.Title = cont
-			case "og:description":
-				og.Description = cont
-			case "og:image":
-				og.Image = cont
+// resolveOG fills in fallbacks for whatever og is missing and makes its URLs
+// absolute against base, the page the values were fetched from. GlobalOG is
+// the site's own and resolved against its base URL instead.
+func resolveOG(cfg *Config, og OG, to, base string) OG {
+	if og.Image == "" {
+		og.Image = cfg.globalOG()
+	}
+	if og.Image == "" {
+		og.Image = og.fallbackImage()
+	}
+	if og.Title == "" {
+		og.Title = "UniGoods"
+	}
+	if og.Description == "" {
+		og.Description = "UniGoods link"
+	}
+	if og.SiteName == "" {
+		if u, err := url.Parse(to); err == nil {
+			og.SiteName = u.Host
+		}
+	}
+	if og.Image != "" {
+		if abs, err := absolutize(og.Image, base); err == nil {
+			og.Image = encodeURL(abs)
+		}
+	}
+	for _, u := range []*string{&og.TouchIcon, &og.Video, &og.Audio} {
+		if *u != "" {
+			if abs, err := absolutize(*u, base); err == nil {
+				*u = encodeURL(abs)
 			}
 		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
+	}
+	// copy before rewriting so the cached OG keeps the raw hrefs
+	og.Alternates = append([]Alternate(nil), og.Alternates...)
+	for i, a := range og.Alternates {
+		if abs, err := absolutize(a.Href, base); err == nil {
+			og.Alternates[i].Href = abs
 		}
 	}
-	f(doc)
 	return og
 }
 
+// insecureImage handles an http:// og:image on a site served over https,
+// which some platforms and browsers block as mixed content: it is switched to
+// https:// when upgrade is set and only warned about otherwise.
+func insecureImage(cfg *Config, og OG, routePath string, upgrade bool) OG {
+	if !hasPrefixFold(og.Image, "http://") || !strings.HasPrefix(strings.ToLower(cfg.baseURL()), "https://") {
+		return og
+	}
+	if !upgrade {
+		warnf("image for %s is served over http on an https site: %s", routePath, og.Image)
+		return og
+	}
+	debugf("upgrading image for %s to https: %s", routePath, og.Image)
+	og.Image = "https://" + og.Image[len("http://"):]
+	return og
+}
+
+// hasPrefixFold is strings.HasPrefix ignoring ASCII case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// truncateRunes shortens s to at most n runes, ending in an ellipsis when
+// anything was cut. Runes, not bytes, so Korean text isn't split mid
+// character. n <= 0 leaves s alone.
+func truncateRunes(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return strings.TrimRightFunc(string(r[:n-1]), unicode.IsSpace) + "…"
+}
+
+func cleanRoutePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return strings.TrimSuffix(p, "/")
+}
+
+// absolutize resolves raw against baseStr. Protocol-relative URLs
+// ("//cdn.example.com/a.jpg") take the scheme of the base, or https when the
+// base has none; path-relative and absolute paths resolve per RFC 3986.
 func absolutize(raw string, baseStr string) (string, error) {
 	if raw == "" {
 		return raw, nil
@@ -189,48 +967,68 @@ func absolutize(raw string, baseStr string) (string, error) {
 	if err != nil {
 		return raw, err
 	}
-	if u.Host != "" && u.Scheme == "" {
+	if u.Host != "" {
 		u.Scheme = base.Scheme
+		if u.Scheme == "" {
+			u.Scheme = "https"
+		}
 		return u.String(), nil
 	}
 	return base.ResolveReference(u).String(), nil
 }
 
-func buildHTML(path, to string, og OG) string {
-	title := htmlstd.EscapeString(og.Title)
-	desc := htmlstd.EscapeString(og.Description)
-	img := htmlstd.EscapeString(og.Image)
-	shopURL := "https://shop.unigoods.im" + path
-	shopURL = htmlstd.EscapeString(shopURL)
-	toEsc := htmlstd.EscapeString(to)
-
-	tpl := `<!doctype html>
-<html lang="ko">
-<head>
-<meta charset="utf-8">
-<title>%s</title>
-<meta name="viewport" content="width=device-width, initial-scale=1">
-<meta name="description" content="%s">
-<meta name="robots" content="noindex">
-<meta property="og:type" content="website">
-<meta property="og:title" content="%s">
-<meta property="og:description" content="%s">
-<meta property="og:image" content="%s">
-<meta property="og:url" content="%s">
-<meta name="twitter:card" content="summary_large_image">
-<link rel="canonical" href="%s">
-<script>(function(){ window.location.replace("%s"); })();</script>
-<style>html,body{background:#fff;margin:0;height:100%%;display:flex;align-items:center;justify-content:center;font:16px/1.4 -apple-system,BlinkMacSystemFont,Segoe UI,Roboto,Helvetica,Arial,Apple SD Gothic Neo,Noto Sans KR,sans-serif;color:#111}</style>
-</head>
-<body>
-<noscript>자바스크립트가 꺼져 있어요. <a href="%s">여기를 눌러 이동</a>하세요.</noscript>
-</body>
-</html>`
-	return fmt.Sprintf(tpl, title, desc, title, desc, img, shopURL, toEsc, toEsc, toEsc)
+// encodeURL percent-encodes spaces and non-ASCII characters in the path and
+// query of raw while leaving existing %XX escapes untouched.
+func encodeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	// the path needs no help: url.URL re-escapes it when the raw form is not
+	// a valid encoding, but RawQuery is emitted verbatim
+	u.RawQuery = escapeLoose(u.RawQuery)
+	u.RawFragment = escapeLoose(u.EscapedFragment())
+	return u.String()
+}
+
+// escapeLoose percent-encodes bytes that are never valid unescaped in a URL
+// (controls, space, non-ASCII and a few delimiters) and keeps valid %XX.
+func escapeLoose(s string) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '%' && i+2 < len(s) && ishex(s[i+1]) && ishex(s[i+2]) {
+			sb.WriteByte(c)
+			continue
+		}
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"<>\^`+"`{|}%", c) >= 0 {
+			sb.WriteByte('%')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&15])
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+func ishex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// joinURL appends a route path to a base URL with exactly one slash between.
+func joinURL(base, path string) string {
+	base = strings.TrimRight(base, "/")
+	if path == "" || path == "/" {
+		return base + "/"
+	}
+	return base + "/" + strings.TrimLeft(path, "/")
 }
 
+// must exits on err with the code it was tagged with by withCode.
 func must(err error) {
 	if err != nil {
-		log.Fatal(err)
+		exitf(exitCode(err), "%v", err)
 	}
 }
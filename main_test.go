@@ -0,0 +1,1265 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		wantBase string
+		wantPage string // pageURL of "/a"
+	}{
+		{"explicit", Config{BaseURL: "https://preview.example.com", CNAME: "s.example.com"}, "https://preview.example.com", "https://preview.example.com/a"},
+		{"from CNAME", Config{CNAME: "s.example.com"}, "https://s.example.com", "https://s.example.com/a"},
+		{"trailing slash", Config{BaseURL: "https://preview.example.com//"}, "https://preview.example.com", "https://preview.example.com/a"},
+		{"with a path", Config{BaseURL: "https://example.com/shop/"}, "https://example.com/shop", "https://example.com/shop/a"},
+		{"neither", Config{}, "", "/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.baseURL(); got != tt.wantBase {
+				t.Errorf("baseURL() = %q, want %q", got, tt.wantBase)
+			}
+			if got := tt.cfg.pageURL("/a", Route{}); got != tt.wantPage {
+				t.Errorf("pageURL(/a) = %q, want %q", got, tt.wantPage)
+			}
+		})
+	}
+}
+
+func TestRoutePath(t *testing.T) {
+	tests := []struct {
+		basePath, key, want string
+	}{
+		{"", "/", ""},
+		{"", "a", "/a"},
+		{"", "/a/", "/a"},
+		{"shop", "/", "/shop"},
+		{"shop", "a", "/shop/a"},
+		{"/shop/sub/", "/a/", "/shop/sub/a"},
+		{" /shop ", "a/b", "/shop/a/b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.basePath+"|"+tt.key, func(t *testing.T) {
+			cfg := Config{BasePath: tt.basePath}
+			if got := cfg.routePath(tt.key); got != tt.want {
+				t.Errorf("routePath(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Route
+	}{
+		{"string", `"https://shop.example.com/p"`, Route{To: "https://shop.example.com/p"}},
+		{"object", `{"to": "https://shop.example.com/p", "title": "T", "description": "D", "image": "https://cdn.example.com/i.jpg"}`,
+			Route{To: "https://shop.example.com/p", Title: "T", Description: "D", Image: "https://cdn.example.com/i.jpg"}},
+		{"object without overrides", `{"to": "https://shop.example.com/p"}`, Route{To: "https://shop.example.com/p"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Route
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	fetched := OG{Title: "Fetched", Description: "Fetched desc", Image: "https://cdn.example.com/f.jpg", ImageAlt: "alt", ImageWidth: 1200, ImageHeight: 630}
+	tests := []struct {
+		name  string
+		route Route
+		want  OG
+	}{
+		{"none", Route{}, fetched},
+		{"title", Route{Title: "Mine"}, OG{Title: "Mine", Description: "Fetched desc", Image: "https://cdn.example.com/f.jpg", ImageAlt: "alt", ImageWidth: 1200, ImageHeight: 630}},
+		{"description", Route{Description: "Mine"}, OG{Title: "Fetched", Description: "Mine", Image: "https://cdn.example.com/f.jpg", ImageAlt: "alt", ImageWidth: 1200, ImageHeight: 630}},
+		{"image drops the fetched image's details", Route{Image: "https://cdn.example.com/m.jpg"}, OG{Title: "Fetched", Description: "Fetched desc", Image: "https://cdn.example.com/m.jpg"}},
+		{"same image keeps them", Route{Image: "https://cdn.example.com/f.jpg"}, fetched},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.route.applyOverrides(fetched); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newSite serves pages, keyed by path, as HTML.
+func newSite(t *testing.T, pages map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testOptions writes cfg to a temp dir and returns the command-line
+// defaults for generating it into a fresh -out there.
+func testOptions(t *testing.T, cfg string) *options {
+	t.Helper()
+	dir := t.TempDir()
+	o := &options{
+		cfgPath:        filepath.Join(dir, "routes.json"),
+		outDir:         filepath.Join(dir, "out"),
+		concurrency:    4,
+		cacheTTL:       24 * time.Hour,
+		format:         "html",
+		maxBody:        defaultMaxBody,
+		timeout:        5 * time.Second,
+		userAgent:      defaultUserAgent,
+		mirrorMaxBytes: 5 << 20,
+		maxDescription: 200,
+		pageBudget:     16 << 10,
+		qrModule:       8,
+		noJekyll:       true,
+	}
+	if err := os.WriteFile(o.cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// outFiles lists the files under dir as slash-separated relative paths, or
+// nil when dir does not exist.
+func outFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestGenerateFiles(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	tests := []struct {
+		name string
+		cfg  string // %[1]s is the test site's URL
+		opts func(*options)
+		want []string
+	}{
+		{
+			name: "routes",
+			cfg:  `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "b/c": "%[1]s/p"}}`,
+			want: []string{".generated", ".nojekyll", "CNAME", "a/index.html", "b/c/index.html"},
+		},
+		{
+			name: "dry run",
+			cfg:  `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`,
+			opts: func(o *options) { o.dryRun = true },
+		},
+		{
+			name: "without .nojekyll",
+			cfg:  `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`,
+			opts: func(o *options) { o.noJekyll = false },
+			want: []string{".generated", "CNAME", "a/index.html"},
+		},
+		{
+			name: "html siblings",
+			cfg:  `{"cname": "s.example.com", "routes": {"/": "%[1]s/p", "/a": "%[1]s/p", "/b/c/": "%[1]s/p"}}`,
+			opts: func(o *options) { o.htmlSiblings = true },
+			want: []string{".generated", ".nojekyll", "CNAME", "a/index.html", "a.html", "b/c/index.html", "b/c.html", "index.html"},
+		},
+		{
+			name: "no CNAME file with only a base URL",
+			cfg:  `{"baseURL": "https://s.example.com", "routes": {"/a": "%[1]s/p"}}`,
+			want: []string{".generated", ".nojekyll", "a/index.html"},
+		},
+		{
+			name: "allow empty",
+			cfg:  `{"cname": "s.example.com", "defaultRedirect": "%[1]s/"}`,
+			opts: func(o *options) { o.allowEmpty = true },
+			want: []string{".generated", ".nojekyll", "404.html", "CNAME"},
+		},
+		{
+			name: "normalized CNAME",
+			cfg:  `{"cname": " HTTPS://S.Example.com/ ", "routes": {"/a": "%[1]s/p"}}`,
+			want: []string{".generated", ".nojekyll", "CNAME", "a/index.html"},
+		},
+		{
+			name: "qr codes",
+			cfg:  `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`,
+			opts: func(o *options) { o.qr = true },
+			want: []string{".generated", ".nojekyll", "CNAME", "a/index.html", "a/qr.png"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(tt.cfg, site.URL))
+			if tt.opts != nil {
+				tt.opts(o)
+			}
+			if err := generate(context.Background(), o); err != nil {
+				t.Fatal(err)
+			}
+			if got := outFiles(t, o.outDir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerate404(t *testing.T) {
+	site := newSite(t, map[string]string{
+		"/p": `<meta property="og:title" content="P">`,
+		"/home": `<meta property="og:title" content="Shop home">
+			<meta property="og:description" content="Everything in stock">
+			<meta property="og:image" content="/img/home.png">`,
+		"/bare": `<meta property="og:title" content="Bare">`,
+	})
+	tests := []struct {
+		name    string
+		cfg     string   // %[1]s is the test site's URL
+		want    []string // so is %[1]s here
+		notWant []string
+	}{
+		{
+			name: "fetched OG",
+			cfg:  `{"cname": "s.example.com", "defaultRedirect": "%[1]s/home", "globalOG": "https://cdn.example.com/g.png", "routes": {"/a": "%[1]s/p"}}`,
+			want: []string{
+				`<meta property="og:title" content="Shop home">`,
+				`<meta property="og:description" content="Everything in stock">`,
+				`<meta property="og:image" content="%[1]s/img/home.png">`,
+			},
+			notWant: []string{"UniGoods", "https://cdn.example.com/g.png"},
+		},
+		{
+			name: "partial OG keeps the fallbacks",
+			cfg:  `{"cname": "s.example.com", "defaultRedirect": "%[1]s/bare", "globalOG": "https://cdn.example.com/g.png", "routes": {"/a": "%[1]s/p"}}`,
+			want: []string{
+				`<meta property="og:title" content="Bare">`,
+				`<meta property="og:description" content="유니굿즈 숍으로 이동합니다.">`,
+				`<meta property="og:image" content="https://cdn.example.com/g.png">`,
+			},
+		},
+		{
+			name: "failed fetch",
+			cfg:  `{"cname": "s.example.com", "defaultRedirect": "%[1]s/gone", "globalOG": "https://cdn.example.com/g.png", "routes": {"/a": "%[1]s/p"}}`,
+			want: []string{
+				`<meta property="og:title" content="UniGoods">`,
+				`<meta property="og:description" content="유니굿즈 숍으로 이동합니다.">`,
+				`<meta property="og:image" content="https://cdn.example.com/g.png">`,
+			},
+		},
+		{
+			name: "failed fetch with a relative globalOG",
+			cfg:  `{"cname": "s.example.com", "defaultRedirect": "%[1]s/gone", "globalOG": "/assets/logo-og.png", "routes": {"/a": "%[1]s/p"}}`,
+			want: []string{`<meta property="og:image" content="https://s.example.com/assets/logo-og.png">`},
+		},
+		{
+			name:    "partial OG with a relative globalOG",
+			cfg:     `{"cname": "s.example.com", "defaultRedirect": "%[1]s/bare", "globalOG": "/assets/logo-og.png", "routes": {"/a": "%[1]s/p"}}`,
+			want:    []string{`<meta property="og:image" content="https://s.example.com/assets/logo-og.png">`},
+			notWant: []string{"%[1]s/assets/logo-og.png"},
+		},
+		{
+			name: "wildcards only",
+			cfg:  `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/p/*": "%[1]s/p/"}}`,
+			want: []string{
+				`<meta property="og:title" content="UniGoods">`,
+				`<meta property="og:description" content="유니굿즈 숍으로 이동합니다.">`,
+			},
+			notWant: []string{`property="og:image"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(tt.cfg, site.URL))
+			if err := generate(context.Background(), o); err != nil {
+				t.Fatal(err)
+			}
+			page, ok := readOut(t, o.outDir)["404.html"]
+			if !ok {
+				t.Fatal("no 404.html")
+			}
+			for _, w := range tt.want {
+				if w = strings.ReplaceAll(w, "%[1]s", site.URL); !strings.Contains(page, w) {
+					t.Errorf("404.html is missing %s", w)
+				}
+			}
+			for _, w := range tt.notWant {
+				if w = strings.ReplaceAll(w, "%[1]s", site.URL); strings.Contains(page, w) {
+					t.Errorf("404.html contains %s", w)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateBaseURLOverride(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	tests := []struct {
+		name    string
+		cfg     string // %[1]s is the test site's URL
+		baseURL string // the -base-url flag
+		want    string // og:url of /a
+		page    string // a/index.html when empty
+	}{
+		{"CNAME", `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`, "", "https://s.example.com/a", ""},
+		{"config base URL wins over CNAME", `{"cname": "s.example.com", "baseURL": "https://www.example.com", "routes": {"/a": "%[1]s/p"}}`, "", "https://www.example.com/a", ""},
+		{"flag wins over CNAME", `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`, "https://pr-7.preview.example.com/", "https://pr-7.preview.example.com/a", ""},
+		{"flag wins over config base URL", `{"cname": "s.example.com", "baseURL": "https://www.example.com", "routes": {"/a": "%[1]s/p"}}`, "https://pr-7.preview.example.com", "https://pr-7.preview.example.com/a", ""},
+		{"flag keeps the base path", `{"cname": "s.example.com", "basePath": "shop", "routes": {"/a": "%[1]s/p"}}`, "https://pr-7.preview.example.com", "https://pr-7.preview.example.com/shop/a", "shop/a/index.html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(tt.cfg, site.URL))
+			o.baseURL = tt.baseURL
+			o.sitemap = true
+			if err := generate(context.Background(), o); err != nil {
+				t.Fatal(err)
+			}
+			files := readOut(t, o.outDir)
+			page := files[cmp.Or(tt.page, "a/index.html")]
+			for _, w := range []string{
+				`<meta property="og:url" content="` + tt.want + `">`,
+				`<link rel="canonical" href="` + tt.want + `">`,
+			} {
+				if !strings.Contains(page, w) {
+					t.Errorf("page does not contain %s:\n%s", w, page)
+				}
+			}
+			if !strings.Contains(files["sitemap.xml"], "<loc>"+tt.want+"</loc>") {
+				t.Errorf("sitemap does not list %s:\n%s", tt.want, files["sitemap.xml"])
+			}
+		})
+	}
+}
+
+func TestAbsolutize(t *testing.T) {
+	tests := []struct {
+		raw, base, want string
+	}{
+		{"https://cdn.example.com/a.jpg", "https://shop.example.com/p/1", "https://cdn.example.com/a.jpg"},
+		{"//cdn.example.com/a.jpg", "https://shop.example.com/p/1", "https://cdn.example.com/a.jpg"},
+		{"//cdn.example.com/a.jpg", "http://shop.example.com/p/1", "http://cdn.example.com/a.jpg"},
+		{"//cdn.example.com/a.jpg", "", "https://cdn.example.com/a.jpg"},
+		{"/img/a.jpg", "https://shop.example.com/p/1", "https://shop.example.com/img/a.jpg"},
+		{"a.jpg", "https://shop.example.com/p/1", "https://shop.example.com/p/a.jpg"},
+		{"../a.jpg", "https://shop.example.com/p/1/", "https://shop.example.com/p/a.jpg"},
+		{"", "https://shop.example.com/", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := absolutize(tt.raw, tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("absolutize(%q, %q) = %q, want %q", tt.raw, tt.base, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeURL(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"https://cdn.example.com/a.jpg", "https://cdn.example.com/a.jpg"},
+		{"https://cdn.example.com/my image.jpg", "https://cdn.example.com/my%20image.jpg"},
+		{"https://cdn.example.com/상품.jpg", "https://cdn.example.com/%EC%83%81%ED%92%88.jpg"},
+		{"https://cdn.example.com/a%20b.jpg", "https://cdn.example.com/a%20b.jpg"},
+		{"https://cdn.example.com/a.jpg?w=100&t=a b", "https://cdn.example.com/a.jpg?w=100&t=a%20b"},
+		{"https://cdn.example.com/a.jpg?q=%ZZ", "https://cdn.example.com/a.jpg?q=%25ZZ"},
+		{"https://cdn.example.com/a.jpg?q=한", "https://cdn.example.com/a.jpg?q=%ED%95%9C"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := encodeURL(tt.raw); got != tt.want {
+				t.Errorf("encodeURL(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveOGImage(t *testing.T) {
+	const base = "https://shop.example.com/p/1"
+	tests := []struct {
+		name   string
+		global string
+		og     OG
+		want   string
+	}{
+		{"og:image wins", "https://cdn.example.com/g.png", OG{Image: "/og.png", TouchIcon: "/t.png"}, "https://shop.example.com/og.png"},
+		{"global before fallbacks", "https://cdn.example.com/g.png", OG{TouchIcon: "/t.png"}, "https://cdn.example.com/g.png"},
+		{"relative global", "/assets/logo-og.png", OG{TouchIcon: "/t.png"}, "https://s.example.com/assets/logo-og.png"},
+		{"relative og:image with a relative global", "/assets/logo-og.png", OG{Image: "og.png"}, "https://shop.example.com/p/og.png"},
+		{"touch icon", "", OG{TouchIcon: "/t.png", Favicon: "/f.png"}, "https://shop.example.com/t.png"},
+		{"favicon", "", OG{Favicon: "f.ico", BodyImage: "/b.jpg"}, "https://shop.example.com/p/f.ico"},
+		{"body image", "", OG{BodyImage: "/img/b c.jpg"}, "https://shop.example.com/img/b%20c.jpg"},
+		{"nothing", "", OG{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveOG(&Config{CNAME: "s.example.com", GlobalOG: tt.global}, tt.og, base, base)
+			if got.Image != tt.want {
+				t.Errorf("image = %q, want %q", got.Image, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigGlobalOG(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"unset", Config{CNAME: "s.example.com"}, ""},
+		{"absolute", Config{CNAME: "s.example.com", GlobalOG: "https://cdn.example.com/g.png"}, "https://cdn.example.com/g.png"},
+		{"root-relative", Config{CNAME: "s.example.com", GlobalOG: " /assets/logo-og.png "}, "https://s.example.com/assets/logo-og.png"},
+		{"relative", Config{CNAME: "s.example.com", GlobalOG: "assets/로고.png"}, "https://s.example.com/assets/%EB%A1%9C%EA%B3%A0.png"},
+		{"base URL", Config{CNAME: "s.example.com", BaseURL: "https://preview.example.com/shop/", GlobalOG: "assets/g.png"}, "https://preview.example.com/shop/assets/g.png"},
+		{"protocol-relative", Config{CNAME: "s.example.com", GlobalOG: "//cdn.example.com/g.png"}, "https://cdn.example.com/g.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.globalOG(); got != tt.want {
+				t.Errorf("globalOG() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveOGMedia(t *testing.T) {
+	const base = "https://shop.example.com/p/1"
+	tests := []struct {
+		name                 string
+		og                   OG
+		wantVideo, wantAudio string
+	}{
+		{"absolute", OG{Video: "https://cdn.example.com/v.mp4", Audio: "https://cdn.example.com/a.mp3"}, "https://cdn.example.com/v.mp4", "https://cdn.example.com/a.mp3"},
+		{"relative", OG{Video: "/media/demo clip.mp4", Audio: "a.mp3"}, "https://shop.example.com/media/demo%20clip.mp4", "https://shop.example.com/p/a.mp3"},
+		{"protocol-relative", OG{Video: "//cdn.example.com/v.mp4"}, "https://cdn.example.com/v.mp4", ""},
+		{"none", OG{}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveOG(&Config{}, tt.og, base, base)
+			if got.Video != tt.wantVideo || got.Audio != tt.wantAudio {
+				t.Errorf("video, audio = %q, %q, want %q, %q", got.Video, got.Audio, tt.wantVideo, tt.wantAudio)
+			}
+		})
+	}
+}
+
+func TestInsecureImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		image    string
+		upgrade  bool
+		want     string
+		wantWarn bool
+	}{
+		{"https image", Config{CNAME: "s.example.com"}, "https://cdn.example.com/a.jpg", false, "https://cdn.example.com/a.jpg", false},
+		{"warn only", Config{CNAME: "s.example.com"}, "http://cdn.example.com/a.jpg", false, "http://cdn.example.com/a.jpg", true},
+		{"upgrade", Config{CNAME: "s.example.com"}, "http://cdn.example.com/a.jpg?w=1", true, "https://cdn.example.com/a.jpg?w=1", false},
+		{"uppercase scheme", Config{CNAME: "s.example.com"}, "HTTP://cdn.example.com/a.jpg", true, "https://cdn.example.com/a.jpg", false},
+		{"http site", Config{BaseURL: "http://s.example.com"}, "http://cdn.example.com/a.jpg", true, "http://cdn.example.com/a.jpg", false},
+		{"uppercase https base URL", Config{BaseURL: "HTTPS://s.example.com"}, "http://cdn.example.com/a.jpg", false, "http://cdn.example.com/a.jpg", true},
+		{"no image", Config{CNAME: "s.example.com"}, "", true, "", false},
+		{"relative image", Config{CNAME: "s.example.com"}, "/a.jpg", true, "/a.jpg", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			got := insecureImage(&tt.cfg, OG{Image: tt.image}, "/a", tt.upgrade)
+			if got.Image != tt.want {
+				t.Errorf("image = %q, want %q", got.Image, tt.want)
+			}
+			warned := strings.Contains(logs.String(), "warn: image for /a is served over http on an https site: "+tt.image)
+			if warned != tt.wantWarn {
+				t.Errorf("warned %v, want %v:\n%s", warned, tt.wantWarn, logs)
+			}
+		})
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	tests := []struct {
+		name    string
+		cfg     string // %[1]s is the test site's URL
+		route   string
+		module  int
+		wantURL string
+	}{
+		{"page URL", `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`, "a", 8, "https://s.example.com/a"},
+		{"base path", `{"cname": "s.example.com", "basePath": "shop", "routes": {"/a": "%[1]s/p"}}`, "shop/a", 8, "https://s.example.com/shop/a"},
+		{"trailing slash", `{"cname": "s.example.com", "canonicalSlash": "add", "routes": {"/a": "%[1]s/p"}}`, "a", 8, "https://s.example.com/a/"},
+		{"module size", `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`, "a", 3, "https://s.example.com/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(tt.cfg, site.URL))
+			o.qr = true
+			o.qrModule = tt.module
+			if err := generate(context.Background(), o); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(filepath.Join(o.outDir, tt.route, "qr.png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			img, err := png.Decode(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := qrcode.New(tt.wantURL, qrcode.Medium)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readModules(img, tt.module); !reflect.DeepEqual(got, q.Bitmap()) {
+				t.Errorf("qr.png does not encode %q", tt.wantURL)
+			}
+		})
+	}
+}
+
+// readModules samples the centre of each module-sized cell of a QR code
+// image, true for dark.
+func readModules(img image.Image, module int) [][]bool {
+	b := img.Bounds()
+	n := b.Dx() / module
+	bits := make([][]bool, n)
+	for y := range bits {
+		bits[y] = make([]bool, n)
+		for x := range bits[y] {
+			r, g, bl, _ := img.At(b.Min.X+x*module+module/2, b.Min.Y+y*module+module/2).RGBA()
+			bits[y][x] = r+g+bl < 3*0x8000
+		}
+	}
+	return bits
+}
+
+// captureLog redirects the standard logger to a buffer for the rest of the
+// test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestGenerateSummary(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	tests := []struct {
+		name          string
+		cfg           string // %[1]s is the test site's URL
+		failOnOGError bool
+		wantSummary   string
+		wantCode      int // 0 for no error
+	}{
+		{
+			name:        "all fetched",
+			cfg:         `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b": "%[1]s/p"}}`,
+			wantSummary: "summary: 2 routes, 2 OG ok, 0 OG failed (using fallbacks), 4 files written, 0 unchanged",
+		},
+		{
+			name:        "failures use fallbacks",
+			cfg:         `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b": "%[1]s/gone"}}`,
+			wantSummary: "summary: 2 routes, 1 OG ok, 1 OG failed (using fallbacks), 4 files written, 0 unchanged",
+		},
+		{
+			name:          "fail on OG error",
+			cfg:           `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b": "%[1]s/gone"}}`,
+			failOnOGError: true,
+			wantSummary:   "summary: 2 routes, 1 OG ok, 1 OG failed (using fallbacks), 4 files written, 0 unchanged",
+			wantCode:      exitFetch,
+		},
+		{
+			name:          "fail on OG error without failures",
+			cfg:           `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`,
+			failOnOGError: true,
+			wantSummary:   "summary: 1 routes, 1 OG ok, 0 OG failed (using fallbacks), 3 files written, 0 unchanged",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(tt.cfg, site.URL))
+			o.failOnOGError = tt.failOnOGError
+			o.retries = 0
+			logs := captureLog(t)
+			err := generate(context.Background(), o)
+			switch {
+			case tt.wantCode == 0 && err != nil:
+				t.Fatalf("generate() = %v, want no error", err)
+			case tt.wantCode != 0 && exitCode(err) != tt.wantCode:
+				t.Fatalf("generate() = %v with exit code %d, want %d", err, exitCode(err), tt.wantCode)
+			}
+			if !strings.Contains(logs.String(), tt.wantSummary) {
+				t.Errorf("log does not contain %q:\n%s", tt.wantSummary, logs)
+			}
+		})
+	}
+}
+
+func TestResolveOGAlternates(t *testing.T) {
+	og := OG{Alternates: []Alternate{{Lang: "ko", Href: "/ko/p"}, {Lang: "en", Href: "https://en.example.com/p"}}}
+	got := resolveOG(&Config{}, og, "https://shop.example.com/p", "https://shop.example.com/p")
+	want := []Alternate{{Lang: "ko", Href: "https://shop.example.com/ko/p"}, {Lang: "en", Href: "https://en.example.com/p"}}
+	if !reflect.DeepEqual(got.Alternates, want) {
+		t.Errorf("alternates = %+v, want %+v", got.Alternates, want)
+	}
+	if og.Alternates[0].Href != "/ko/p" {
+		t.Errorf("resolveOG rewrote the cached alternates: %+v", og.Alternates)
+	}
+}
+
+func TestGenerateDeterministic(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	var routes []string
+	for _, p := range []string{"/m", "/b", "/z", "/a", "/k/1", "/k", "/c"} {
+		routes = append(routes, fmt.Sprintf("%q: %q", p, site.URL+"/p"))
+	}
+	cfg := `{"cname": "s.example.com", "routes": {` + strings.Join(routes, ", ") + `}}`
+	wantOrder := []string{"/a", "/b", "/c", "/k", "/k/1", "/m", "/z"}
+
+	var first map[string]string
+	for run := 0; run < 3; run++ {
+		o := testOptions(t, cfg)
+		o.sitemap = true
+		o.manifestPath = filepath.Join(o.outDir, "..", "manifest.json")
+		if err := generate(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]string{}
+		for _, path := range []string{filepath.Join(o.outDir, "sitemap.xml"), o.manifestPath} {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[filepath.Base(path)] = string(b)
+		}
+
+		var manifest []manifestEntry
+		if err := json.Unmarshal([]byte(got["manifest.json"]), &manifest); err != nil {
+			t.Fatal(err)
+		}
+		var order []string
+		for _, e := range manifest {
+			order = append(order, e.Path)
+		}
+		if !reflect.DeepEqual(order, wantOrder) {
+			t.Errorf("manifest order %q, want %q", order, wantOrder)
+		}
+		last := -1
+		for _, p := range wantOrder {
+			i := strings.Index(got["sitemap.xml"], "<loc>https://s.example.com"+p+"</loc>")
+			if i < last {
+				t.Errorf("sitemap lists %s out of order", p)
+			}
+			last = i
+		}
+
+		// fetch times differ between runs; everything else must not
+		got["manifest.json"] = regexp.MustCompile(`"fetchedAt": "[^"]*"`).ReplaceAllString(got["manifest.json"], "")
+		if first == nil {
+			first = got
+		} else if !reflect.DeepEqual(got, first) {
+			t.Errorf("run %d differs from the first:\n%v\nfirst:\n%v", run+1, got, first)
+		}
+	}
+}
+
+func TestGenerateSingle(t *testing.T) {
+	site := newSite(t, map[string]string{
+		"/p": `<meta property="og:title" content="Old">`,
+		"/q": `<meta property="og:title" content="New">`,
+	})
+	tests := []struct {
+		name        string
+		single      string
+		wantChanged []string
+		wantCode    int // 0 for no error
+	}{
+		{"route", "/a", []string{"a/index.html"}, 0},
+		{"path as typed", "a/", []string{"a/index.html"}, 0},
+		{"nested", "/b/c", []string{"b/c/index.html"}, 0},
+		{"missing", "/x", nil, exitConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b/c": "%[1]s/p"}}`, site.URL))
+			if err := generate(context.Background(), o); err != nil {
+				t.Fatal(err)
+			}
+			before := readOut(t, o.outDir)
+			writeFile(t, o.cfgPath, fmt.Sprintf(`{"cname": "x.example.com", "routes": {"/a": "%[1]s/q", "/b/c": "%[1]s/q"}}`, site.URL))
+			o.single = tt.single
+			err := generate(context.Background(), o)
+			switch {
+			case tt.wantCode == 0 && err != nil:
+				t.Fatalf("generate() = %v, want no error", err)
+			case tt.wantCode != 0 && exitCode(err) != tt.wantCode:
+				t.Fatalf("generate() = %v with exit code %d, want %d", err, exitCode(err), tt.wantCode)
+			}
+			after := readOut(t, o.outDir)
+			var changed []string
+			for name, b := range after {
+				if before[name] != b {
+					changed = append(changed, name)
+				}
+			}
+			if len(after) != len(before) {
+				t.Errorf("files %q, want %q", sortedKeys(after), sortedKeys(before))
+			}
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("changed %q, want %q", changed, tt.wantChanged)
+			}
+		})
+	}
+}
+
+// readOut returns the contents of every file under dir by relative path.
+func readOut(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	for _, name := range outFiles(t, dir) {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(b)
+	}
+	return files
+}
+
+func TestNormalizeCNAME(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"x.com", "x.com", false},
+		{"https://x.com/", "x.com", false},
+		{"  X.COM  ", "x.com", false},
+		{"http://Shop.Example.com/path?q=1#f", "shop.example.com", false},
+		{"x.com.", "x.com", false},
+		{"", "", false},
+		{"x.com y.com", "", true},
+		{"x.com,y.com", "", true},
+		{"user@x.com", "", true},
+		{"x.com:8080", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := normalizeCNAME(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeCNAME(%q) = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeCNAME(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigCNAME(t *testing.T) {
+	tests := []struct {
+		cname   string
+		want    string
+		wantErr bool
+	}{
+		{"https://x.com/", "x.com", false},
+		{"  X.COM  ", "x.com", false},
+		{"x.com", "x.com", false},
+		{"x.com y.com", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cname, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "routes.json")
+			writeFile(t, path, fmt.Sprintf(`{"cname": %q, "routes": {"/a": "https://shop.example.com/a"}}`, tt.cname))
+			cfg, err := loadConfig(path, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.CNAME != tt.want {
+				t.Errorf("cname = %q, want %q", cfg.CNAME, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		yaml string
+	}{
+		{
+			name: "plain routes",
+			json: `{"cname": "s.example.com", "routes": {"/a": "https://shop.example.com/a", "/b/": "https://shop.example.com/b"}}`,
+			yaml: "cname: s.example.com\nroutes:\n  /a: https://shop.example.com/a\n  /b/: https://shop.example.com/b\n",
+		},
+		{
+			name: "object routes",
+			json: `{"cname": "s.example.com", "redirectDelay": 2, "defaultUTM": {"utm_source": "qr"},
+				"routes": {"/a": {"to": "https://shop.example.com/a", "title": "A", "index": true,
+				"variants": [{"to": "https://shop.example.com/a1", "weight": 2.5}, {"to": "https://shop.example.com/a2"}]}}}`,
+			yaml: `cname: s.example.com
+redirectDelay: 2
+defaultUTM:
+  utm_source: qr
+routes:
+  /a:
+    to: https://shop.example.com/a
+    title: A
+    index: true
+    variants:
+      - to: https://shop.example.com/a1
+        weight: 2.5
+      - to: https://shop.example.com/a2
+`,
+		},
+		{
+			name: "lists",
+			json: `{"cname": "s.example.com", "allowedHosts": ["shop.example.com", ".unigoods.im"], "routes": {"/a": "https://shop.example.com/a"}}`,
+			yaml: "cname: s.example.com\nallowedHosts: [shop.example.com, .unigoods.im]\nroutes:\n  /a: https://shop.example.com/a\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var cfgs []*Config
+			for name, data := range map[string]string{"routes.json": tt.json, "routes.yaml": tt.yaml, "routes.YML": tt.yaml} {
+				path := filepath.Join(dir, name)
+				writeFile(t, path, data)
+				cfg, err := loadConfig(path, false)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				cfgs = append(cfgs, cfg)
+			}
+			if cfgs[0].CNAME != "s.example.com" || len(cfgs[0].Routes) == 0 {
+				t.Fatalf("config not loaded: %+v", cfgs[0])
+			}
+			for _, cfg := range cfgs[1:] {
+				if !reflect.DeepEqual(cfg, cfgs[0]) {
+					t.Errorf("configs differ:\n%+v\n%+v", cfg, cfgs[0])
+				}
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	writeFile(t, path, "routes: [a\n")
+	if _, err := loadConfig(path, false); exitCode(err) != exitConfig {
+		t.Errorf("loadConfig() of invalid YAML = %v, want a config error", err)
+	}
+}
+
+func TestLoadConfigRouteMarkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string // routes.json unless it ends in .yaml
+		config  string
+		want    []string // route keys left
+		wantLog string
+		wantErr string
+	}{
+		{
+			name:   "comment keys",
+			config: `{"cname": "s.example.com", "routes": {"// summer sale": "ends in august", "//": {"note": 1}, "/a": "https://shop.example.com/a"}}`,
+			want:   []string{"/a"},
+		},
+		{
+			name:   "comment values are not expanded",
+			config: `{"cname": "s.example.com", "routes": {"// uses ${UNIGOODS_UNSET}": "x", "/a": "https://shop.example.com/a"}}`,
+			want:   []string{"/a"},
+		},
+		{
+			name:    "disabled route",
+			config:  `{"cname": "s.example.com", "routes": {"/a": "https://shop.example.com/a", "/b": {"to": "https://shop.example.com/b", "disabled": true}, "/c": {"to": "https://shop.example.com/c", "disabled": false}}}`,
+			want:    []string{"/a", "/c"},
+			wantLog: "skipping disabled route /b",
+		},
+		{
+			name:    "YAML",
+			file:    "routes.yaml",
+			config:  "cname: s.example.com\nroutes:\n  // old: https://shop.example.com/old\n  /a: https://shop.example.com/a\n  /b:\n    to: https://shop.example.com/b\n    disabled: true\n",
+			want:    []string{"/a"},
+			wantLog: "skipping disabled route /b",
+		},
+		{
+			name:   "null config",
+			config: `null`,
+			want:   []string{},
+		},
+		{
+			name:   "empty YAML",
+			file:   "routes.yaml",
+			config: "",
+			want:   []string{},
+		},
+		{
+			name:    "array",
+			config:  `[{"/a": "https://shop.example.com/a"}]`,
+			wantErr: "the config must be an object, not []interface {}",
+		},
+		{
+			name:    "string",
+			file:    "routes.yaml",
+			config:  "just a string\n",
+			wantErr: "the config must be an object, not string",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), cmp.Or(tt.file, "routes.json"))
+			writeFile(t, path, tt.config)
+			logs := captureLog(t)
+			cfg, err := loadConfig(path, true)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || exitCode(err) != exitConfig {
+					t.Fatalf("loadConfig() = %v (exit code %d), want a config error containing %q", err, exitCode(err), tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sortedRoutes(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("routes = %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("log does not contain %q:\n%s", tt.wantLog, logs)
+			}
+		})
+	}
+}
+
+func TestGenerateCleansDisabledRoutes(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b": "%[1]s/p"}}`, site.URL))
+	o.clean = true
+	if err := generate(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, o.cfgPath, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b": {"to": "%[1]s/p", "disabled": true}, "// /c": "%[1]s/p"}}`, site.URL))
+	if err := generate(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := outFiles(t, o.outDir), []string{".generated", ".nojekyll", "CNAME", "a/index.html"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
+
+func TestCanonicalSlash(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    map[string]string // route key to its og:url and canonical
+		wantErr bool
+	}{
+		{"", map[string]string{"/": "https://s.example.com/", "/a": "https://s.example.com/a", "/b/": "https://s.example.com/b"}, false},
+		{"strip", map[string]string{"/": "https://s.example.com/", "/a": "https://s.example.com/a", "/b/": "https://s.example.com/b"}, false},
+		{"add", map[string]string{"/": "https://s.example.com/", "/a": "https://s.example.com/a/", "/b/": "https://s.example.com/b/"}, false},
+		{"preserve", map[string]string{"/": "https://s.example.com/", "/a": "https://s.example.com/a", "/b/": "https://s.example.com/b/"}, false},
+		{"keep", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.policy, "default"), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "routes.json")
+			writeFile(t, path, `{"cname": "s.example.com", "canonicalSlash": "`+tt.policy+`", "routes": {
+				"/": "https://shop.example.com/", "/a": "https://shop.example.com/a", "/b/": "https://shop.example.com/b"}}`)
+			cfg, err := loadConfig(path, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			sitemap := buildSitemap(cfg, nil)
+			for key, want := range tt.want {
+				page, err := buildHTML(nil, cfg, cleanRoutePath(key), cfg.Routes[key], OG{Title: "t"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, w := range []string{`<meta property="og:url" content="` + want + `">`, `<link rel="canonical" href="` + want + `">`} {
+					if !strings.Contains(page, w) {
+						t.Errorf("%s: page does not contain %s", key, w)
+					}
+				}
+				if !strings.Contains(sitemap, "<loc>"+want+"</loc>") {
+					t.Errorf("%s: sitemap does not list %s:\n%s", key, want, sitemap)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigSource(t *testing.T) {
+	const (
+		jsonCfg = `{"cname": "s.example.com", "routes": {"/a": "https://shop.example.com/a"}}`
+		yamlCfg = "cname: s.example.com\nroutes:\n  /a: https://shop.example.com/a\n"
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/routes.json", "/config":
+			io.WriteString(w, jsonCfg)
+		case "/routes.yaml":
+			io.WriteString(w, yamlCfg)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		path    string // %[1]s is the test server's URL, %[2]s its host
+		stdin   string
+		wantErr bool
+	}{
+		{"stdin", "-", jsonCfg, false},
+		{"stdin is JSON only", "-", yamlCfg, true},
+		{"empty stdin", "-", "", true},
+		{"URL", "%[1]s/routes.json", "", false},
+		{"URL without an extension", "%[1]s/config", "", false},
+		{"YAML URL", "%[1]s/routes.yaml?v=2", "", false},
+		{"uppercase scheme", "HTTP://%[2]s/routes.json", "", false},
+		{"missing URL", "%[1]s/gone.json", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.path == "-" {
+				stdin := filepath.Join(t.TempDir(), "stdin")
+				writeFile(t, stdin, tt.stdin)
+				f, err := os.Open(stdin)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				old := os.Stdin
+				os.Stdin = f
+				defer func() { os.Stdin = old }()
+			}
+			path := strings.NewReplacer("%[1]s", srv.URL, "%[2]s", srv.Listener.Addr().String()).Replace(tt.path)
+			cfg, err := loadConfig(path, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig(%q) = %v, wantErr %v", path, err, tt.wantErr)
+			}
+			if err != nil {
+				if exitCode(err) != exitConfig {
+					t.Errorf("exit code %d, want %d", exitCode(err), exitConfig)
+				}
+				return
+			}
+			if cfg.CNAME != "s.example.com" || cfg.Routes["/a"].To != "https://shop.example.com/a" {
+				t.Errorf("config not loaded: %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestWithUTM(t *testing.T) {
+	defaults := map[string]string{"utm_source": "qr", "utm_medium": "print"}
+	tests := []struct {
+		name     string
+		defaults map[string]string
+		route    map[string]string
+		to       string
+		want     string
+	}{
+		{"none", nil, nil, "https://shop.example.com/p?b=2&a=1", "https://shop.example.com/p?b=2&a=1"},
+		{"defaults", defaults, nil, "https://shop.example.com/p", "https://shop.example.com/p?utm_medium=print&utm_source=qr"},
+		{"route override", defaults, map[string]string{"utm_source": "ig", "utm_campaign": "fall"}, "https://shop.example.com/p", "https://shop.example.com/p?utm_campaign=fall&utm_medium=print&utm_source=ig"},
+		{"existing params kept", defaults, nil, "https://shop.example.com/p?id=7&utm_source=email", "https://shop.example.com/p?id=7&utm_source=email&utm_medium=print"},
+		{"already tagged", defaults, nil, "https://shop.example.com/p?z=1&utm_source=a&utm_medium=b&a=%7e", "https://shop.example.com/p?z=1&utm_source=a&utm_medium=b&a=%7e"},
+		{"signed query untouched", defaults, nil, "https://shop.example.com/p?sig=a%2Fb+c&ts=1&id", "https://shop.example.com/p?sig=a%2Fb+c&ts=1&id&utm_medium=print&utm_source=qr"},
+		{"route wins over the target", nil, map[string]string{"utm_source": "ig"}, "https://shop.example.com/p?utm_source=email", "https://shop.example.com/p?utm_source=ig"},
+		{"route replaces in place", nil, map[string]string{"utm_source": "ig"}, "https://shop.example.com/p?b=2&utm_source=email&a=1&utm_source=x", "https://shop.example.com/p?b=2&utm_source=ig&a=1"},
+		{"empty query", defaults, nil, "https://shop.example.com/p?", "https://shop.example.com/p?utm_medium=print&utm_source=qr"},
+		{"fragment kept", defaults, nil, "https://shop.example.com/p#reviews", "https://shop.example.com/p?utm_medium=print&utm_source=qr#reviews"},
+		{"values escaped", map[string]string{"utm_campaign": "fall sale&more"}, nil, "https://shop.example.com/p", "https://shop.example.com/p?utm_campaign=fall+sale%26more"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{DefaultUTM: tt.defaults}
+			if got := cfg.withUTM(Route{UTM: tt.route}, tt.to); got != tt.want {
+				t.Errorf("withUTM(%q) = %q, want %q", tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveOGTouchIcon(t *testing.T) {
+	tests := []struct {
+		icon, want string
+	}{
+		{"/touch.png", "https://shop.example.com/touch.png"},
+		{"icons/touch.png", "https://shop.example.com/p/icons/touch.png"},
+		{"//cdn.example.com/touch.png", "https://cdn.example.com/touch.png"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.icon, func(t *testing.T) {
+			const base = "https://shop.example.com/p/1"
+			if got := resolveOG(&Config{}, OG{TouchIcon: tt.icon}, base, base).TouchIcon; got != tt.want {
+				t.Errorf("touch icon = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateInterrupted(t *testing.T) {
+	slowHit := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<meta property="og:title" content="Fast">`)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slowHit <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		run  func(o *options) error
+	}{
+		{
+			name: "deadline",
+			run: func(o *options) error {
+				o.deadline = 300 * time.Millisecond
+				return generate(context.Background(), o)
+			},
+		},
+		{
+			name: "cancelled",
+			run: func(o *options) error {
+				// one at a time, so /a is done by the time /b is hit
+				o.concurrency = 1
+				select {
+				case <-slowHit: // left over from an earlier run
+				default:
+				}
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				go func() {
+					<-slowHit
+					cancel()
+				}()
+				return generate(ctx, o)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%[1]s/fast", "/b": "%[1]s/slow"}}`, srv.URL))
+			stale := filepath.Join(o.outDir, "b", "index.html")
+			if err := os.MkdirAll(filepath.Dir(stale), 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, stale, "previous run")
+
+			start := time.Now()
+			err := tt.run(o)
+			if err == nil || exitCode(err) != exitFailure {
+				t.Fatalf("generate() = %v, want an interrupted run", err)
+			}
+			if d := time.Since(start); d > 3*time.Second {
+				t.Errorf("generate() took %v to stop", d)
+			}
+			page, err := os.ReadFile(filepath.Join(o.outDir, "a", "index.html"))
+			if err != nil || !strings.Contains(string(page), `content="Fast"`) {
+				t.Errorf("completed route not written: %v", err)
+			}
+			if b, _ := os.ReadFile(stale); string(b) != "previous run" {
+				t.Errorf("interrupted route was rewritten:\n%s", b)
+			}
+		})
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	long := strings.Repeat("가", 250)
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"short", "짧은 설명", 200, "짧은 설명"},
+		{"exactly n", "가나다", 3, "가나다"},
+		{"korean", "가나다라마", 4, "가나다…"},
+		{"default length", long, 200, strings.Repeat("가", 199) + "…"},
+		{"no space before the ellipsis", "ab cd", 4, "ab…"},
+		{"ascii", "abcdef", 3, "ab…"},
+		{"emoji", "👍👍👍👍", 3, "👍👍…"},
+		{"off", long, 0, long},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateRunes(tt.s, tt.n)
+			if got != tt.want {
+				t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateRunes() = %q is not valid UTF-8", got)
+			}
+			if tt.n > 0 && utf8.RuneCountInString(got) > tt.n {
+				t.Errorf("truncateRunes() = %d runes, want at most %d", utf8.RuneCountInString(got), tt.n)
+			}
+		})
+	}
+}
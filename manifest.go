@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// manifestEntry describes one generated route for tooling and dashboards.
+type manifestEntry struct {
+	Path        string    `json:"path"`
+	To          string    `json:"to"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Image       string    `json:"image"`
+	FetchedAt   time.Time `json:"fetchedAt"`
+	OK          bool      `json:"ok"`
+}
+
+func writeManifest(path string, entries []manifestEntry) error {
+	if entries == nil {
+		entries = []manifestEntry{}
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteManifest(t *testing.T) {
+	fetched := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		entries []manifestEntry
+		want    string
+	}{
+		{"empty", nil, "[]\n"},
+		{
+			name:    "entry",
+			entries: []manifestEntry{{Path: "/a", To: "https://shop.example.com/a", Title: "A", FetchedAt: fetched, OK: true}},
+			want: `[
+  {
+    "path": "/a",
+    "to": "https://shop.example.com/a",
+    "title": "A",
+    "description": "",
+    "image": "",
+    "fetchedAt": "2024-03-01T12:00:00Z",
+    "ok": true
+  }
+]
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "manifest.json")
+			if err := writeManifest(path, tt.entries); err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("manifest =\n%s\nwant\n%s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateManifest(t *testing.T) {
+	site := newSite(t, map[string]string{
+		"/p": `<meta property="og:title" content="P"><meta property="og:description" content="About P"><meta property="og:image" content="/p.png">`,
+	})
+	tests := []struct {
+		name       string
+		failOnDead bool
+		want       []manifestEntry // FetchedAt is not compared
+	}{
+		{
+			name: "fallbacks for a dead target",
+			want: []manifestEntry{
+				{Path: "/a", To: site.URL + "/p", Title: "P", Description: "About P", Image: site.URL + "/p.png", OK: true},
+				{Path: "/dead", To: site.URL + "/gone", Title: "UniGoods", Description: "UniGoods link", OK: false},
+			},
+		},
+		{
+			name:       "skipped dead target",
+			failOnDead: true,
+			want: []manifestEntry{
+				{Path: "/a", To: site.URL + "/p", Title: "P", Description: "About P", Image: site.URL + "/p.png", OK: true},
+				{Path: "/dead", To: site.URL + "/gone", OK: false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/dead": "%[1]s/gone"}}`, site.URL))
+			o.manifestPath = filepath.Join(t.TempDir(), "manifest.json")
+			o.failOnDead = tt.failOnDead
+			o.retries = 0
+			if err := generate(context.Background(), o); err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(o.manifestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []manifestEntry
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			for i := range got {
+				got[i].FetchedAt = time.Time{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("manifest = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
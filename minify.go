@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// blockTags are elements around which whitespace never renders, so the
+// whitespace between them and their neighbours can go.
+var blockTags = map[string]bool{
+	"html": true, "head": true, "body": true, "title": true, "meta": true,
+	"link": true, "script": true, "style": true, "noscript": true, "base": true,
+	"div": true, "p": true, "ul": true, "ol": true, "li": true, "table": true,
+	"thead": true, "tbody": true, "tr": true, "td": true, "th": true,
+	"header": true, "footer": true, "main": true, "section": true, "nav": true,
+	"article": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "br": true, "hr": true,
+}
+
+// minifyHTML drops comments and the whitespace templates leave between
+// block-level tags, and collapses other whitespace runs to one space. Tags are
+// kept byte for byte, as are <pre> and <textarea> contents; <script> and
+// <style> bodies only lose indentation and blank lines. Conditional comments
+// (<!--[if ...]>) stay.
+func minifyHTML(page string) string {
+	type token struct {
+		typ  xhtml.TokenType
+		tag  string
+		raw  string
+		keep bool // inside <pre> or <textarea>
+	}
+	var toks []token
+	z := xhtml.NewTokenizer(strings.NewReader(page))
+	preserve, raw := 0, ""
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		t := token{typ: tt, raw: string(z.Raw()), keep: preserve > 0}
+		if tt == xhtml.StartTagToken || tt == xhtml.EndTagToken || tt == xhtml.SelfClosingTagToken {
+			name, _ := z.TagName()
+			t.tag = string(name)
+			switch {
+			case t.tag != "pre" && t.tag != "textarea":
+			case tt == xhtml.StartTagToken:
+				preserve++
+			case tt == xhtml.EndTagToken && preserve > 0:
+				preserve--
+			}
+			if tt == xhtml.StartTagToken && (t.tag == "script" || t.tag == "style") {
+				raw = t.tag
+			} else {
+				raw = ""
+			}
+		} else if tt == xhtml.TextToken && raw != "" {
+			t.tag = raw // the raw text body of a <script> or <style>
+		}
+		toks = append(toks, t)
+	}
+
+	block := func(i int) bool {
+		return i < 0 || i >= len(toks) || toks[i].typ == xhtml.DoctypeToken || blockTags[toks[i].tag]
+	}
+	var b bytes.Buffer
+	b.Grow(len(page))
+	for i, t := range toks {
+		switch {
+		case t.keep:
+			b.WriteString(t.raw)
+		case t.typ == xhtml.CommentToken:
+			if strings.HasPrefix(t.raw, "<!--[if") {
+				b.WriteString(t.raw)
+			}
+		case t.typ == xhtml.TextToken && t.tag != "":
+			b.WriteString(trimLines(t.raw))
+		case t.typ == xhtml.TextToken:
+			text := strings.Join(strings.Fields(t.raw), " ")
+			if text == "" {
+				if block(i-1) || block(i+1) {
+					continue
+				}
+				text = " "
+			} else {
+				if isSpace(t.raw[0]) && !block(i-1) {
+					text = " " + text
+				}
+				if isSpace(t.raw[len(t.raw)-1]) && !block(i+1) {
+					text += " "
+				}
+			}
+			b.WriteString(text)
+		default:
+			b.WriteString(t.raw)
+		}
+	}
+	return b.String()
+}
+
+// trimLines strips the indentation and blank lines of a script or style
+// body. Line breaks stay, as JavaScript may rely on them in place of
+// semicolons, and a body with a template literal is left alone since its
+// lines may be part of a string.
+func trimLines(body string) string {
+	if strings.Contains(body, "`") {
+		return strings.TrimSpace(body)
+	}
+	var lines []string
+	for _, l := range strings.Split(body, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	xhtml "golang.org/x/net/html"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "whitespace between block tags",
+			in:   "<!DOCTYPE html>\n<html>\n  <head>\n    <title>T</title>\n  </head>\n</html>\n",
+			want: "<!DOCTYPE html><html><head><title>T</title></head></html>",
+		},
+		{
+			name: "whitespace between inline tags",
+			in:   "<p>a  <b>bold</b>\n\n<i>it</i>  z</p>",
+			want: "<p>a <b>bold</b> <i>it</i> z</p>",
+		},
+		{
+			name: "text next to block tags",
+			in:   "<div>\n  some\n  text\n</div>",
+			want: "<div>some text</div>",
+		},
+		{
+			name: "comments",
+			in:   "<p>a<!-- note --></p>\n<!-- another\n note -->\n<p>b</p>",
+			want: "<p>a</p><p>b</p>",
+		},
+		{
+			name: "conditional comment",
+			in:   "<head>\n<!--[if IE]><link rel=\"stylesheet\" href=\"/ie.css\"><![endif]-->\n</head>",
+			want: "<head><!--[if IE]><link rel=\"stylesheet\" href=\"/ie.css\"><![endif]--></head>",
+		},
+		{
+			name: "tags kept byte for byte",
+			in:   "<div>\n<a  href=\"/x\"   class=\"c\">x</a>\n</div>",
+			want: "<div><a  href=\"/x\"   class=\"c\">x</a></div>",
+		},
+		{
+			name: "pre",
+			in:   "<div>\n<pre>  a\n\n  b <b> c </b></pre>\n</div>",
+			want: "<div><pre>  a\n\n  b <b> c </b></pre></div>",
+		},
+		{
+			name: "textarea",
+			in:   "<p>\n<textarea>  x\n  y  </textarea>\n</p>",
+			want: "<p><textarea>  x\n  y  </textarea></p>",
+		},
+		{
+			name: "script",
+			in:   "<script>\n  var a = 1\n\n  var b = a  +  2;\n</script>",
+			want: "<script>var a = 1\nvar b = a  +  2;</script>",
+		},
+		{
+			name: "script keeps comment-like text",
+			in:   "<script>var s = \"<!-- x -->\";</script>",
+			want: "<script>var s = \"<!-- x -->\";</script>",
+		},
+		{
+			name: "script with a template literal",
+			in:   "<script>\n  var s = `a\n\n    b`;\n</script>",
+			want: "<script>var s = `a\n\n    b`;</script>",
+		},
+		{
+			name: "style",
+			in:   "<style>\n  body {\n    margin: 0;\n  }\n</style>",
+			want: "<style>body {\nmargin: 0;\n}</style>",
+		},
+		{
+			name: "already minified",
+			in:   "<html><head><title>T</title></head><body><p>a <b>b</b></p></body></html>",
+			want: "<html><head><title>T</title></head><body><p>a <b>b</b></p></body></html>",
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := minifyHTML(tt.in)
+			if got != tt.want {
+				t.Errorf("minifyHTML() =\n%q\nwant\n%q", got, tt.want)
+			}
+			if again := minifyHTML(got); again != got {
+				t.Errorf("minifying twice gives\n%q\nwant\n%q", again, got)
+			}
+		})
+	}
+}
+
+func TestMinifyPage(t *testing.T) {
+	wildcards := map[string]Route{"/p/*": {To: "https://shop.example.com/products/"}}
+	tests := []struct {
+		name     string
+		cfg      Config
+		route    Route
+		notFound bool // build the 404 page instead
+		golden   string
+	}{
+		{
+			name:   "page",
+			cfg:    Config{CNAME: "s.example.com"},
+			route:  Route{To: "https://shop.example.com/p/1"},
+			golden: "minified-page.html",
+		},
+		{
+			name: "analytics, brand and includes",
+			cfg: Config{
+				CNAME:       "s.example.com",
+				Analytics:   Analytics{GA4: "G-TEST", Plausible: "s.example.com"},
+				Brand:       Brand{Accent: "#ff5a00", Text: "잠시만요"},
+				HeadInclude: "<!-- head -->\n<meta name=\"x\" content=\"y\">",
+				BodyInclude: "<div>\n  <p>footer   text</p>\n</div>",
+			},
+			route:  Route{To: "https://shop.example.com/p/1"},
+			golden: "minified-page-extras.html",
+		},
+		{
+			name:     "404 page with wildcards",
+			cfg:      Config{CNAME: "s.example.com", DefaultRedirect: "https://shop.example.com", Routes: wildcards},
+			notFound: true,
+			golden:   "minified-404.html",
+		},
+	}
+	og := OG{Title: "유니굿즈  티셔츠", Description: "Soft  cotton\ntee", Image: "https://cdn.example.com/tee.png", SiteName: "UniGoods"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := buildHTML(nil, &tt.cfg, "/a", tt.route, og)
+			if tt.notFound {
+				page, err = build404HTML(nil, &tt.cfg, og)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := minifyHTML(page)
+			if len(got) >= len(page) {
+				t.Errorf("minified page is %d bytes, not smaller than %d", len(got), len(page))
+			}
+			if strings.Contains(got, "<!--") {
+				t.Errorf("minified page keeps a comment:\n%s", got)
+			}
+			if want, gotOutline := pageOutline(t, page), pageOutline(t, got); !reflect.DeepEqual(gotOutline, want) {
+				t.Errorf("minified page differs from the original:\n%s\nwant\n%s", strings.Join(gotOutline, "\n"), strings.Join(want, "\n"))
+			}
+
+			golden := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("minifyHTML() =\n%s\nwant (%s)\n%s", got, golden, want)
+			}
+		})
+	}
+}
+
+func TestGenerateMinify(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	cfg := strings.ReplaceAll(`{"cname": "s.example.com", "defaultRedirect": "%[1]s/p", "routes": {"/a": "%[1]s/p", "/b/*": "%[1]s/p"}}`, "%[1]s", site.URL)
+	outputs := map[bool]map[string]string{}
+	for _, minify := range []bool{false, true} {
+		o := testOptions(t, cfg)
+		o.minify = minify
+		if err := generate(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+		outputs[minify] = readOut(t, o.outDir)
+	}
+	plain, minified := outputs[false], outputs[true]
+	if len(plain) != len(minified) {
+		t.Fatalf("wrote %d files with -minify, %d without", len(minified), len(plain))
+	}
+	for name, page := range plain {
+		if !strings.HasSuffix(name, ".html") {
+			continue
+		}
+		got := minified[name]
+		if len(got) >= len(page) {
+			t.Errorf("%s is %d bytes with -minify, %d without", name, len(got), len(page))
+		}
+		if !reflect.DeepEqual(pageOutline(t, got), pageOutline(t, page)) {
+			t.Errorf("%s differs with -minify:\n%s\nwithout:\n%s", name, got, page)
+		}
+	}
+}
+
+// pageOutline lists what a browser makes of page: each element with its
+// attributes, and the text between them with whitespace runs collapsed.
+// Script and style bodies are listed line by line without indentation.
+// Comments are left out.
+func pageOutline(t *testing.T, page string) []string {
+	t.Helper()
+	doc, err := xhtml.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	var walk func(n *xhtml.Node)
+	walk = func(n *xhtml.Node) {
+		switch n.Type {
+		case xhtml.ElementNode:
+			s := "<" + n.Data
+			for _, a := range n.Attr {
+				s += " " + a.Key + "=" + a.Val
+			}
+			out = append(out, s+">")
+		case xhtml.TextNode:
+			if p := n.Parent; p != nil && (p.Data == "script" || p.Data == "style") {
+				out = append(out, trimLines(n.Data))
+			} else if text := strings.Join(strings.Fields(n.Data), " "); text != "" {
+				out = append(out, text)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return out
+}
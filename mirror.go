@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// imageMirror downloads OG images into the output directory so previews keep
+// working when the third-party URL rots or blocks hotlinking.
+type imageMirror struct {
+	ctx      context.Context
+	f        *fetcher
+	out      *output
+	cfg      *Config
+	maxBytes int64
+	done     map[string]mirroredImage
+}
+
+// mirroredImage is a self-hosted copy of an image. Width and Height are zero
+// for formats the standard library cannot decode.
+type mirroredImage struct {
+	URL           string
+	Width, Height int
+}
+
+var imageExts = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/avif":    ".avif",
+	"image/svg+xml": ".svg",
+}
+
+// mirror saves src under /_og/<hash><ext> and returns its public URL along
+// with its dimensions.
+func (m *imageMirror) mirror(src string) (mirroredImage, error) {
+	if img, ok := m.done[src]; ok {
+		return img, nil
+	}
+	req, err := http.NewRequestWithContext(m.ctx, "GET", src, nil)
+	if err != nil {
+		return mirroredImage{}, err
+	}
+	req.Header.Set("User-Agent", m.f.userAgent)
+	res, err := m.f.client.Do(req)
+	if err != nil {
+		return mirroredImage{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return mirroredImage{}, &statusError{Code: res.StatusCode, URL: src}
+	}
+	if res.ContentLength > m.maxBytes {
+		return mirroredImage{}, fmt.Errorf("%s is %d bytes, over the %d byte limit", src, res.ContentLength, m.maxBytes)
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		return mirroredImage{}, fmt.Errorf("%s is %q, not an image", src, mediaType)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, m.maxBytes+1))
+	if err != nil {
+		return mirroredImage{}, err
+	}
+	if int64(len(body)) > m.maxBytes {
+		return mirroredImage{}, fmt.Errorf("%s exceeds the %d byte limit", src, m.maxBytes)
+	}
+
+	ext, ok := imageExts[mediaType]
+	if !ok {
+		ext = path.Ext(res.Request.URL.Path)
+	}
+	sum := sha256.Sum256([]byte(src))
+	rp := m.cfg.routePath("/_og/" + hex.EncodeToString(sum[:8]) + ext)
+	if err := m.out.write(strings.TrimPrefix(rp, "/"), body); err != nil {
+		return mirroredImage{}, err
+	}
+	img := mirroredImage{URL: joinURL(m.cfg.baseURL(), rp)}
+	if c, _, err := image.DecodeConfig(bytes.NewReader(body)); err == nil {
+		img.Width, img.Height = c.Width, c.Height
+	}
+	m.done[src] = img
+	return img, nil
+}
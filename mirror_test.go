@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestImageMirror(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(contentType string, body []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write(body)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/a.png", serve("image/png", pngData.Bytes()))
+	mux.Handle("/photo", serve("image/jpeg", []byte("not really a jpeg")))
+	mux.Handle("/icon.svg", serve("image/svg+xml", []byte("<svg></svg>")))
+	mux.Handle("/page", serve("text/html", []byte("<html></html>")))
+	mux.Handle("/huge.png", serve("image/png", make([]byte, 2048)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name          string
+		path          string
+		wantExt       string
+		width, height int
+		wantErr       bool
+	}{
+		{"png", "/a.png", ".png", 40, 30, false},
+		{"extension from the content type", "/photo", ".jpg", 0, 0, false},
+		{"svg", "/icon.svg", ".svg", 0, 0, false},
+		{"not an image", "/page", "", 0, 0, true},
+		{"over the limit", "/huge.png", "", 0, 0, true},
+		{"missing", "/gone.png", "", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &output{dir: t.TempDir()}
+			m := &imageMirror{
+				ctx:      context.Background(),
+				f:        newFetcher(time.Second, ""),
+				out:      out,
+				cfg:      &Config{CNAME: "s.example.com"},
+				maxBytes: 1024,
+				done:     map[string]mirroredImage{},
+			}
+			img, err := m.mirror(srv.URL + tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mirror() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if files := outFiles(t, out.dir); len(files) > 0 {
+					t.Errorf("wrote %q for a failed mirror", files)
+				}
+				return
+			}
+			re := regexp.MustCompile(`^https://s\.example\.com/_og/[0-9a-f]{16}` + regexp.QuoteMeta(tt.wantExt) + `$`)
+			if !re.MatchString(img.URL) {
+				t.Errorf("URL = %q, want it to match %s", img.URL, re)
+			}
+			if img.Width != tt.width || img.Height != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", img.Width, img.Height, tt.width, tt.height)
+			}
+			local := filepath.Join(out.dir, filepath.FromSlash(img.URL[len("https://s.example.com/"):]))
+			if _, err := os.Stat(local); err != nil {
+				t.Errorf("mirrored file: %v", err)
+			}
+		})
+	}
+}
+
+func TestGenerateMirroredImageDimensions(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		page string
+		want []string
+	}{
+		{
+			name: "computed from the download",
+			page: `<meta property="og:image" content="/a.png">`,
+			want: []string{`<meta property="og:image:width" content="40">`, `<meta property="og:image:height" content="30">`},
+		},
+		{
+			name: "the target's own dimensions win",
+			page: `<meta property="og:image" content="/a.png"><meta property="og:image:width" content="400"><meta property="og:image:height" content="300">`,
+			want: []string{`<meta property="og:image:width" content="400">`, `<meta property="og:image:height" content="300">`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/p", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte(tt.page))
+			})
+			mux.HandleFunc("/a.png", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write(pngData.Bytes())
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			o := testOptions(t, `{"cname": "s.example.com", "routes": {"/a": "`+srv.URL+`/p"}}`)
+			o.mirrorImages = true
+			if err := generate(context.Background(), o); err != nil {
+				t.Fatal(err)
+			}
+			page, err := os.ReadFile(filepath.Join(o.outDir, "a", "index.html"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range append(tt.want, `<meta property="og:image" content="https://s.example.com/_og/`) {
+				if !bytes.Contains(page, []byte(w)) {
+					t.Errorf("page does not contain %s:\n%s", w, page)
+				}
+			}
+		})
+	}
+}
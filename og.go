@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+type OG struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	ImageAlt    string `json:"imageAlt,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+	ThemeColor  string `json:"themeColor,omitempty"`
+
+	Locale           string   `json:"locale,omitempty"`
+	LocaleAlternates []string `json:"localeAlternates,omitempty"`
+
+	// og:image:width/height; only meaningful when both are set
+	ImageWidth  int `json:"imageWidth,omitempty"`
+	ImageHeight int `json:"imageHeight,omitempty"`
+
+	Alternates []Alternate `json:"alternates,omitempty"`
+
+	// og:video and og:audio of media targets, the first of each
+	Video       string `json:"video,omitempty"`
+	VideoType   string `json:"videoType,omitempty"`
+	VideoWidth  int    `json:"videoWidth,omitempty"`
+	VideoHeight int    `json:"videoHeight,omitempty"`
+	Audio       string `json:"audio,omitempty"`
+	AudioType   string `json:"audioType,omitempty"`
+
+	// article:* tags of blog and news targets
+	PublishedTime string   `json:"publishedTime,omitempty"`
+	ModifiedTime  string   `json:"modifiedTime,omitempty"`
+	Authors       []string `json:"authors,omitempty"`
+
+	// last-resort image candidates, tried in this order after GlobalOG
+	TouchIcon string `json:"touchIcon,omitempty"`
+	Favicon   string `json:"favicon,omitempty"`
+	BodyImage string `json:"bodyImage,omitempty"`
+}
+
+// firstURL returns the first URL of an image value that holds several,
+// comma-separated or srcset style with descriptors like "2x", e.g.
+// "a.jpg 1x, b.jpg 2x" or "https://x/a.jpg,https://x/b.jpg". A comma is only
+// a separator next to whitespace or a descriptor, or before another URL,
+// since CDN paths such as /w_100,h_100/ use commas too. Whitespace alone
+// doesn't end the URL, as some pages leave spaces unencoded.
+func firstURL(s string) string {
+	s = strings.TrimSpace(s)
+	for i := strings.IndexByte(s, ','); i >= 0; {
+		rest := s[i+1:]
+		next := strings.TrimLeft(rest, " \t\n\r\f")
+		if next != rest || strings.HasSuffix(s[:i], " ") || next == "" || endsWithDescriptor(s[:i]) ||
+			strings.HasPrefix(next, "/") || hasPrefixFold(next, "http://") || hasPrefixFold(next, "https://") {
+			s = strings.TrimSpace(s[:i])
+			break
+		}
+		j := strings.IndexByte(rest, ',')
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+	// drop the candidate's own descriptor, as in "a.jpg 2x"
+	if endsWithDescriptor(s) {
+		s = strings.TrimSpace(s[:strings.LastIndexAny(s, " \t\n\r\f")])
+	}
+	return s
+}
+
+// endsWithDescriptor reports whether s ends in a whitespace-separated srcset
+// descriptor.
+func endsWithDescriptor(s string) bool {
+	k := strings.LastIndexAny(s, " \t\n\r\f")
+	return k >= 0 && srcsetDescriptor.MatchString(s[k+1:])
+}
+
+// srcsetDescriptor matches a srcset width or density descriptor such as
+// "640w" or "2x", optionally followed by the comma ending the candidate.
+var srcsetDescriptor = regexp.MustCompile(`^\d+(\.\d+)?[wx],?$`)
+
+// Alternate is a <link rel="alternate" hreflang> language variant.
+type Alternate struct {
+	Lang string `json:"lang"`
+	Href string `json:"href"`
+}
+
+// fallbackImage returns the first of the page's apple-touch-icon, favicon
+// and first reasonably sized <img>.
+func (og OG) fallbackImage() string {
+	for _, img := range []string{og.TouchIcon, og.Favicon, og.BodyImage} {
+		if img != "" {
+			return img
+		}
+	}
+	return ""
+}
+
+// parseOG transcodes body to UTF-8 using the charset from contentType or the
+// document's own <meta> declaration before extracting OG values. The page is
+// tokenized as it streams in, and reading stops at the end of <head> once
+// og:title, og:description and og:image are all known, since nothing later
+// in the page could take precedence over them. The error is that of a failed
+// read, in which case og holds what was found before it.
+func parseOG(body io.Reader, contentType, base string) (og OG, err error) {
+	br := bufio.NewReader(body)
+	r, cerr := charset.NewReader(br, contentType)
+	if cerr != nil {
+		// e.g. an empty body; parse the bytes as they are
+		r = br
+	}
+	z := xhtml.NewTokenizer(r)
+	var tw, ld OG
+	var docTitle, metaDesc, secureImage, secureVideo, secureAudio string
+	foreign := 0 // depth inside <svg>/<math>, whose <title> etc. aren't the page's
+	inBody := false
+	complete := func() bool { return og.Title != "" && og.Description != "" && og.Image != "" }
+scan:
+	for {
+		tt := z.Next()
+		switch tt {
+		case xhtml.ErrorToken:
+			if z.Err() != io.EOF {
+				err = z.Err()
+			}
+			break scan
+		case xhtml.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "svg", "math":
+				foreign = max(foreign-1, 0)
+			case "head":
+				if complete() {
+					break scan
+				}
+			}
+			continue
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+		default:
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		tag := string(name)
+		var attrs []xhtml.Attribute
+		for hasAttr {
+			var k, v []byte
+			k, v, hasAttr = z.TagAttr()
+			attrs = append(attrs, xhtml.Attribute{Key: string(k), Val: string(v)})
+		}
+		if tag == "body" {
+			inBody = true
+			if complete() {
+				break scan
+			}
+		}
+		if foreign > 0 || tag == "svg" || tag == "math" {
+			if tt == xhtml.StartTagToken && (tag == "svg" || tag == "math") {
+				foreign++
+			}
+			if tag != "meta" {
+				continue
+			}
+		}
+
+		switch tag {
+		case "link":
+			href := strings.TrimSpace(attr(attrs, "href"))
+			for _, rel := range strings.Fields(strings.ToLower(attr(attrs, "rel"))) {
+				switch {
+				case href == "":
+				case strings.HasPrefix(rel, "apple-touch-icon") && og.TouchIcon == "":
+					og.TouchIcon = href
+				case rel == "icon" && og.Favicon == "":
+					og.Favicon = href
+				case rel == "alternate":
+					if lang := strings.TrimSpace(attr(attrs, "hreflang")); lang != "" {
+						og.Alternates = append(og.Alternates, Alternate{Lang: lang, Href: href})
+					}
+				}
+			}
+		case "img":
+			if src := strings.TrimSpace(attr(attrs, "src")); og.BodyImage == "" && src != "" && !strings.HasPrefix(src, "data:") && !tinyImage(attrs) {
+				og.BodyImage = src
+			}
+		case "script":
+			if tt == xhtml.StartTagToken && strings.EqualFold(strings.TrimSpace(attr(attrs, "type")), "application/ld+json") {
+				var v any
+				if json.Unmarshal([]byte(nextText(z)), &v) == nil {
+					walkJSONLD(v, &ld)
+				}
+			}
+		case "title":
+			if tt == xhtml.StartTagToken && !inBody && docTitle == "" {
+				docTitle = strings.TrimSpace(nextText(z))
+			}
+		case "meta":
+			var prop, name, cont string
+			for _, a := range attrs {
+				switch a.Key {
+				case "property":
+					prop = strings.ToLower(strings.TrimSpace(a.Val))
+				case "name":
+					name = strings.ToLower(strings.TrimSpace(a.Val))
+				case "content":
+					cont = strings.TrimSpace(a.Val)
+				}
+			}
+			key := prop
+			if key == "" {
+				key = name
+			}
+			switch key {
+			case "og:title":
+				og.Title = cont
+			case "og:description":
+				og.Description = cont
+			case "og:image", "og:image:url":
+				if og.Image == "" {
+					og.Image = firstURL(cont)
+				}
+			case "og:image:secure_url":
+				if secureImage == "" {
+					secureImage = firstURL(cont)
+				}
+			case "og:image:alt":
+				if og.ImageAlt == "" {
+					og.ImageAlt = cont
+				}
+			case "og:image:width":
+				if n, err := strconv.Atoi(cont); err == nil && n > 0 && og.ImageWidth == 0 {
+					og.ImageWidth = n
+				}
+			case "og:image:height":
+				if n, err := strconv.Atoi(cont); err == nil && n > 0 && og.ImageHeight == 0 {
+					og.ImageHeight = n
+				}
+			case "og:site_name":
+				og.SiteName = cont
+			case "og:locale":
+				og.Locale = cont
+			case "og:video", "og:video:url":
+				if og.Video == "" {
+					og.Video = cont
+				}
+			case "og:video:secure_url":
+				if secureVideo == "" {
+					secureVideo = cont
+				}
+			case "og:video:type":
+				if og.VideoType == "" {
+					og.VideoType = cont
+				}
+			case "og:video:width":
+				if n, err := strconv.Atoi(cont); err == nil && n > 0 && og.VideoWidth == 0 {
+					og.VideoWidth = n
+				}
+			case "og:video:height":
+				if n, err := strconv.Atoi(cont); err == nil && n > 0 && og.VideoHeight == 0 {
+					og.VideoHeight = n
+				}
+			case "og:audio", "og:audio:url":
+				if og.Audio == "" {
+					og.Audio = cont
+				}
+			case "og:audio:secure_url":
+				if secureAudio == "" {
+					secureAudio = cont
+				}
+			case "og:audio:type":
+				if og.AudioType == "" {
+					og.AudioType = cont
+				}
+			case "article:published_time":
+				og.PublishedTime = cont
+			case "article:modified_time":
+				og.ModifiedTime = cont
+			case "article:author":
+				if cont != "" {
+					og.Authors = append(og.Authors, cont)
+				}
+			case "og:locale:alternate":
+				if cont != "" {
+					og.LocaleAlternates = append(og.LocaleAlternates, cont)
+				}
+			}
+			switch name {
+			case "description":
+				metaDesc = cont
+			case "theme-color":
+				// the first one; later ones usually carry a dark-mode media query
+				if og.ThemeColor == "" {
+					og.ThemeColor = cont
+				}
+			case "twitter:title":
+				tw.Title = cont
+			case "twitter:description":
+				tw.Description = cont
+			case "twitter:image", "twitter:image:src":
+				tw.Image = firstURL(cont)
+			case "twitter:image:alt":
+				tw.ImageAlt = cont
+			}
+		}
+	}
+	if secureImage != "" && (og.Image == "" || strings.HasPrefix(strings.ToLower(base), "https:")) {
+		og.Image = secureImage
+	}
+	if secureVideo != "" && (og.Video == "" || strings.HasPrefix(strings.ToLower(base), "https:")) {
+		og.Video = secureVideo
+	}
+	if secureAudio != "" && (og.Audio == "" || strings.HasPrefix(strings.ToLower(base), "https:")) {
+		og.Audio = secureAudio
+	}
+	if og.Title == "" {
+		og.Title = tw.Title
+	}
+	if og.Title == "" {
+		og.Title = ld.Title
+	}
+	if og.Title == "" {
+		og.Title = docTitle
+	}
+	if og.Description == "" {
+		og.Description = tw.Description
+	}
+	if og.Description == "" {
+		og.Description = metaDesc
+	}
+	if og.Image == "" {
+		// the dimensions describe og:image, not whatever replaces it
+		og.ImageWidth, og.ImageHeight = 0, 0
+		og.Image, og.ImageAlt = tw.Image, ""
+	}
+	if og.ImageAlt == "" && og.Image != "" && og.Image == tw.Image {
+		og.ImageAlt = tw.ImageAlt
+	}
+	if og.Image == "" {
+		og.Image = ld.Image
+	}
+	// the tokenizer has already decoded entities once; sites that encode
+	// twice leave "&amp;" or "&#39;" in the text, which would otherwise be
+	// escaped again on output
+	for _, s := range []*string{&og.Title, &og.Description, &og.SiteName, &og.ImageAlt} {
+		*s = html.UnescapeString(*s)
+	}
+	return og, err
+}
+
+// walkJSONLD fills ld.Title from the first headline/name and ld.Image from
+// the first image found in a decoded JSON-LD value, descending into arrays
+// and @graph. Organizations, sites and the like are skipped as they describe
+// the publisher rather than the page.
+func walkJSONLD(v any, ld *OG) {
+	switch v := v.(type) {
+	case []any:
+		for _, e := range v {
+			walkJSONLD(e, ld)
+		}
+	case map[string]any:
+		if !publisherType(v["@type"]) {
+			for _, k := range []string{"headline", "name"} {
+				if s, ok := v[k].(string); ok && ld.Title == "" && strings.TrimSpace(s) != "" {
+					ld.Title = strings.TrimSpace(s)
+				}
+			}
+			if ld.Image == "" {
+				ld.Image = jsonLDImage(v["image"])
+			}
+		}
+		if g, ok := v["@graph"]; ok {
+			walkJSONLD(g, ld)
+		}
+	}
+}
+
+func publisherType(t any) bool {
+	switch t := t.(type) {
+	case string:
+		switch t {
+		case "Organization", "WebSite", "WebPage", "BreadcrumbList", "Person", "Brand":
+			return true
+		}
+	case []any:
+		for _, e := range t {
+			if publisherType(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonLDImage accepts the string, array and ImageObject forms of "image".
+func jsonLDImage(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case []any:
+		for _, e := range v {
+			if img := jsonLDImage(e); img != "" {
+				return img
+			}
+		}
+	case map[string]any:
+		if u, ok := v["url"].(string); ok {
+			return strings.TrimSpace(u)
+		}
+		if u, ok := v["contentUrl"].(string); ok {
+			return strings.TrimSpace(u)
+		}
+	}
+	return ""
+}
+
+// tinyImage reports whether an <img> declares a width or height too small
+// to make a useful preview, e.g. tracking pixels and icons.
+func tinyImage(attrs []xhtml.Attribute) bool {
+	for _, k := range []string{"width", "height"} {
+		if v, err := strconv.Atoi(strings.TrimSuffix(attr(attrs, k), "px")); err == nil && v < 100 {
+			return true
+		}
+	}
+	return false
+}
+
+// attr returns the value of the attribute named key. The tokenizer already
+// lowercases attribute names.
+func attr(attrs []xhtml.Attribute, key string) string {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// nextText returns the text of the element whose start tag was just read,
+// e.g. a <script> or <title>.
+func nextText(z *xhtml.Tokenizer) string {
+	if z.Next() != xhtml.TextToken {
+		return ""
+	}
+	return string(z.Text())
+}
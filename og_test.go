@@ -0,0 +1,477 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOG(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		base string // defaults to an https page
+		want OG
+	}{
+		{
+			name: "og tags",
+			page: `<html><head>
+				<meta property="og:title" content="Sticker pack">
+				<meta property="og:description" content="Ten stickers">
+				<meta property="og:image" content="https://cdn.example.com/a.jpg">
+				<meta property="og:site_name" content="UniGoods">
+				</head><body></body></html>`,
+			want: OG{Title: "Sticker pack", Description: "Ten stickers", Image: "https://cdn.example.com/a.jpg", SiteName: "UniGoods"},
+		},
+		{
+			name: "site_name is trimmed",
+			page: `<meta property="og:site_name" content="  UniGoods  ">`,
+			want: OG{SiteName: "UniGoods"},
+		},
+		{
+			name: "no site_name",
+			page: `<meta property="og:title" content="Sticker pack">`,
+			want: OG{Title: "Sticker pack"},
+		},
+		{
+			name: "twitter card fallback",
+			page: `<meta name="twitter:title" content="Tw title">
+				<meta name="twitter:description" content="Tw desc">
+				<meta name="twitter:image" content="https://cdn.example.com/tw.jpg">
+				<meta name="twitter:image:alt" content="A sticker">`,
+			want: OG{Title: "Tw title", Description: "Tw desc", Image: "https://cdn.example.com/tw.jpg", ImageAlt: "A sticker"},
+		},
+		{
+			name: "og wins over twitter",
+			page: `<meta name="twitter:title" content="Tw title">
+				<meta property="og:title" content="OG title">
+				<meta name="twitter:image:src" content="https://cdn.example.com/tw.jpg">
+				<meta property="og:image" content="https://cdn.example.com/og.jpg">`,
+			want: OG{Title: "OG title", Image: "https://cdn.example.com/og.jpg"},
+		},
+		{
+			name: "twitter:image:src",
+			page: `<meta name="twitter:image:src" content="https://cdn.example.com/tw.jpg">`,
+			want: OG{Image: "https://cdn.example.com/tw.jpg"},
+		},
+		{
+			name: "document title fallback",
+			page: `<html><head><title>  Doc title  </title></head><body></body></html>`,
+			want: OG{Title: "Doc title"},
+		},
+		{
+			name: "twitter title wins over document title",
+			page: `<title>Doc title</title><meta name="twitter:title" content="Tw title">`,
+			want: OG{Title: "Tw title"},
+		},
+		{
+			name: "svg and body titles are not the page's",
+			page: `<html><head></head><body><svg><title>Icon</title></svg><title>Late</title></body></html>`,
+			want: OG{},
+		},
+		{
+			name: "meta description fallback",
+			page: `<meta name="description" content="Plain description">`,
+			want: OG{Description: "Plain description"},
+		},
+		{
+			name: "twitter description wins over meta description",
+			page: `<meta name="description" content="Plain"><meta name="twitter:description" content="Tw desc">`,
+			want: OG{Description: "Tw desc"},
+		},
+		{
+			name: "og description wins over all",
+			page: `<meta name="description" content="Plain"><meta property="og:description" content="OG desc">`,
+			want: OG{Description: "OG desc"},
+		},
+		{
+			name: "first og:image wins",
+			page: `<meta property="og:image" content="https://cdn.example.com/1.jpg">
+				<meta property="og:image" content="https://cdn.example.com/2.jpg">
+				<meta property="og:image:width" content="1200">
+				<meta property="og:image:width" content="600">`,
+			want: OG{Image: "https://cdn.example.com/1.jpg", ImageWidth: 1200},
+		},
+		{
+			name: "secure_url preferred on https",
+			page: `<meta property="og:image" content="http://cdn.example.com/a.jpg">
+				<meta property="og:image:secure_url" content="https://cdn.example.com/a.jpg">`,
+			want: OG{Image: "https://cdn.example.com/a.jpg"},
+		},
+		{
+			name: "og:image kept on http",
+			page: `<meta property="og:image" content="http://cdn.example.com/a.jpg">
+				<meta property="og:image:secure_url" content="https://cdn.example.com/a.jpg">`,
+			base: "http://example.com/",
+			want: OG{Image: "http://cdn.example.com/a.jpg"},
+		},
+		{
+			name: "secure_url alone",
+			page: `<meta property="og:image:secure_url" content="https://cdn.example.com/a.jpg">`,
+			base: "http://example.com/",
+			want: OG{Image: "https://cdn.example.com/a.jpg"},
+		},
+		{
+			name: "fallback image candidates",
+			page: `<html><head>
+				<link rel="icon" href="/favicon.png">
+				<link rel="apple-touch-icon-precomposed" href="/touch.png">
+				<link rel="apple-touch-icon" href="/touch2.png">
+				</head><body>
+				<img src="data:image/gif;base64,R0lGOD">
+				<img src="/pixel.gif" width="1" height="1">
+				<img src="/logo.png" width="64px">
+				<img src="/hero.jpg" width="800">
+				<img src="/second.jpg">
+				</body></html>`,
+			want: OG{TouchIcon: "/touch.png", Favicon: "/favicon.png", BodyImage: "/hero.jpg"},
+		},
+		{
+			name: "JSON-LD product",
+			page: `<title>Shop</title><script type="application/ld+json">
+				{"@context": "https://schema.org", "@type": "Product", "name": "Hoodie", "image": ["https://example.com/h1.jpg", "https://example.com/h2.jpg"]}
+				</script>`,
+			want: OG{Title: "Hoodie", Image: "https://example.com/h1.jpg"},
+		},
+		{
+			name: "JSON-LD article",
+			page: `<script type="application/ld+json">
+				{"@type": "NewsArticle", "headline": "Sale starts", "name": "ignored", "image": {"@type": "ImageObject", "url": "https://example.com/a.jpg"}}
+				</script>`,
+			want: OG{Title: "Sale starts", Image: "https://example.com/a.jpg"},
+		},
+		{
+			name: "JSON-LD graph skips the publisher",
+			page: `<script type="application/ld+json">
+				{"@graph": [{"@type": "Organization", "name": "UniGoods", "image": "https://example.com/logo.png"}, {"@type": "Product", "name": "Cap", "image": "https://example.com/cap.jpg"}]}
+				</script>`,
+			want: OG{Title: "Cap", Image: "https://example.com/cap.jpg"},
+		},
+		{
+			name: "OG and twitter before JSON-LD",
+			page: `<meta property="og:title" content="OG"><meta name="twitter:image" content="https://example.com/tw.jpg">
+				<script type="application/ld+json">{"@type": "Product", "name": "LD", "image": "https://example.com/ld.jpg"}</script>`,
+			want: OG{Title: "OG", Image: "https://example.com/tw.jpg"},
+		},
+		{
+			name: "invalid JSON-LD",
+			page: `<title>Doc</title><script type="application/ld+json">{"name": </script>`,
+			want: OG{Title: "Doc"},
+		},
+		{
+			name: "image dimensions",
+			page: `<meta property="og:image" content="https://example.com/a.jpg">
+				<meta property="og:image:width" content="1200"><meta property="og:image:height" content=" 630 ">
+				<meta property="og:image:width" content="600">`,
+			want: OG{Image: "https://example.com/a.jpg", ImageWidth: 1200, ImageHeight: 630},
+		},
+		{
+			name: "invalid dimensions",
+			page: `<meta property="og:image" content="https://example.com/a.jpg">
+				<meta property="og:image:width" content="wide"><meta property="og:image:height" content="-1">`,
+			want: OG{Image: "https://example.com/a.jpg"},
+		},
+		{
+			name: "dimensions dropped with a twitter image",
+			page: `<meta name="twitter:image" content="https://example.com/tw.jpg">
+				<meta property="og:image:width" content="1200"><meta property="og:image:height" content="630">`,
+			want: OG{Image: "https://example.com/tw.jpg"},
+		},
+		{
+			name: "image alt",
+			page: `<meta property="og:image" content="https://example.com/a.jpg"><meta property="og:image:alt" content="A red cap">`,
+			want: OG{Image: "https://example.com/a.jpg", ImageAlt: "A red cap"},
+		},
+		{
+			name: "twitter alt for the twitter image",
+			page: `<meta name="twitter:image" content="https://example.com/tw.jpg"><meta name="twitter:image:alt" content="Tweet alt">`,
+			want: OG{Image: "https://example.com/tw.jpg", ImageAlt: "Tweet alt"},
+		},
+		{
+			name: "twitter alt for the same image",
+			page: `<meta property="og:image" content="https://example.com/a.jpg"><meta name="twitter:image" content="https://example.com/a.jpg"><meta name="twitter:image:alt" content="Tweet alt">`,
+			want: OG{Image: "https://example.com/a.jpg", ImageAlt: "Tweet alt"},
+		},
+		{
+			name: "twitter alt for another image",
+			page: `<meta property="og:image" content="https://example.com/a.jpg"><meta name="twitter:image" content="https://example.com/tw.jpg"><meta name="twitter:image:alt" content="Tweet alt">`,
+			want: OG{Image: "https://example.com/a.jpg"},
+		},
+		{
+			name: "theme color",
+			page: `<meta name="theme-color" content="#ff5500"><meta name="theme-color" media="(prefers-color-scheme: dark)" content="#111111">`,
+			want: OG{ThemeColor: "#ff5500"},
+		},
+		{
+			name: "locale",
+			page: `<meta property="og:locale" content="en_US"><meta property="og:locale:alternate" content="ko_KR"><meta property="og:locale:alternate" content="ja_JP">`,
+			want: OG{Locale: "en_US", LocaleAlternates: []string{"ko_KR", "ja_JP"}},
+		},
+		{
+			name: "entities decoded once",
+			page: `<meta property="og:title" content="Tom &amp; Jerry &#44072;"><meta property="og:description" content="It&#39;s here">`,
+			want: OG{Title: "Tom & Jerry 갨", Description: "It's here"},
+		},
+		{
+			name: "double-encoded entities",
+			page: `<meta property="og:title" content="Tom &amp;amp; Jerry"><meta property="og:site_name" content="Tom&amp;#39;s">`,
+			want: OG{Title: "Tom & Jerry", SiteName: "Tom's"},
+		},
+		{
+			name: "text entities",
+			page: `<title>A &lt;b&gt; &amp; C</title>`,
+			want: OG{Title: "A <b> & C"},
+		},
+		{
+			name: "article",
+			page: `<html><head>
+				<meta property="og:type" content="article">
+				<meta property="og:title" content="New drop">
+				<meta property="article:published_time" content="2024-03-01T09:00:00+09:00">
+				<meta property="article:modified_time" content="2024-03-02T18:30:00+09:00">
+				<meta property="article:author" content="https://blog.example.com/authors/kim">
+				<meta property="article:author" content="">
+				<meta property="article:author" content="Lee">
+				<meta property="article:section" content="News">
+				</head><body></body></html>`,
+			want: OG{
+				Title:         "New drop",
+				PublishedTime: "2024-03-01T09:00:00+09:00",
+				ModifiedTime:  "2024-03-02T18:30:00+09:00",
+				Authors:       []string{"https://blog.example.com/authors/kim", "Lee"},
+			},
+		},
+		{
+			name: "article without times",
+			page: `<meta property="og:type" content="article"><meta property="og:title" content="Note">`,
+			want: OG{Title: "Note"},
+		},
+		{
+			name: "video",
+			page: `<html><head>
+				<meta property="og:title" content="Demo">
+				<meta property="og:video" content="http://cdn.example.com/demo.mp4">
+				<meta property="og:video:secure_url" content="https://cdn.example.com/demo.mp4">
+				<meta property="og:video:type" content="video/mp4">
+				<meta property="og:video:width" content="1280">
+				<meta property="og:video:height" content="720">
+				<meta property="og:video" content="https://cdn.example.com/second.mp4">
+				</head><body></body></html>`,
+			want: OG{Title: "Demo", Video: "https://cdn.example.com/demo.mp4", VideoType: "video/mp4", VideoWidth: 1280, VideoHeight: 720},
+		},
+		{
+			name: "video on an http page",
+			page: `<meta property="og:video:url" content="/demo.mp4">
+				<meta property="og:video:secure_url" content="https://cdn.example.com/demo.mp4">
+				<meta property="og:video:width" content="wide">`,
+			base: "http://example.com/",
+			want: OG{Video: "/demo.mp4"},
+		},
+		{
+			name: "secure video only",
+			page: `<meta property="og:video:secure_url" content="https://cdn.example.com/demo.mp4">`,
+			base: "http://example.com/",
+			want: OG{Video: "https://cdn.example.com/demo.mp4"},
+		},
+		{
+			name: "audio",
+			page: `<meta property="og:audio" content="http://cdn.example.com/song.mp3">
+				<meta property="og:audio:secure_url" content="https://cdn.example.com/song.mp3">
+				<meta property="og:audio:type" content="audio/mpeg">`,
+			want: OG{Audio: "https://cdn.example.com/song.mp3", AudioType: "audio/mpeg"},
+		},
+		{
+			name: "og:image with several URLs",
+			page: `<meta property="og:image" content="https://cdn.example.com/a.jpg 1x, https://cdn.example.com/b.jpg 2x">
+				<meta property="og:image:secure_url" content="https://cdn.example.com/s.jpg,https://cdn.example.com/t.jpg">`,
+			want: OG{Image: "https://cdn.example.com/s.jpg"},
+		},
+		{
+			name: "twitter:image with a descriptor",
+			page: `<meta name="twitter:image" content="https://cdn.example.com/tw.jpg 2x">`,
+			want: OG{Image: "https://cdn.example.com/tw.jpg"},
+		},
+		{
+			name: "hreflang alternates",
+			page: `<link rel="alternate" hreflang="ko" href="https://example.com/ko/p">
+				<link rel="alternate" hreflang="en" href="/en/p">
+				<link rel="alternate" type="application/rss+xml" href="/feed">`,
+			want: OG{Alternates: []Alternate{{Lang: "ko", Href: "https://example.com/ko/p"}, {Lang: "en", Href: "/en/p"}}},
+		},
+		{
+			name: "shortcut icon",
+			page: `<link rel="shortcut icon" href="/favicon.ico">`,
+			want: OG{Favicon: "/favicon.ico"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := tt.base
+			if base == "" {
+				base = "https://example.com/"
+			}
+			got, err := parseOG(strings.NewReader(tt.page), "text/html; charset=utf-8", base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOG() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirstURL(t *testing.T) {
+	tests := []struct {
+		name, value, want string
+	}{
+		{"single", "https://cdn.example.com/a.jpg", "https://cdn.example.com/a.jpg"},
+		{"padded", "  https://cdn.example.com/a.jpg \n", "https://cdn.example.com/a.jpg"},
+		{"comma separated", "https://cdn.example.com/a.jpg,https://cdn.example.com/b.jpg", "https://cdn.example.com/a.jpg"},
+		{"comma and space", "https://cdn.example.com/a.jpg, https://cdn.example.com/b.jpg", "https://cdn.example.com/a.jpg"},
+		{"relative list", "/img/a.jpg,/img/b.jpg", "/img/a.jpg"},
+		{"density descriptors", "https://cdn.example.com/a.jpg 1x, https://cdn.example.com/b.jpg 2x", "https://cdn.example.com/a.jpg"},
+		{"width descriptors", "a-640.jpg 640w,a-1280.jpg 1280w", "a-640.jpg"},
+		{"fractional descriptor", "a.jpg 1.5x", "a.jpg"},
+		{"descriptor only on one", "https://cdn.example.com/a.jpg 2x", "https://cdn.example.com/a.jpg"},
+		{"trailing comma", "https://cdn.example.com/a.jpg,", "https://cdn.example.com/a.jpg"},
+		{"CDN transform commas", "https://cdn.example.com/w_1200,h_630,c_fill/a.jpg", "https://cdn.example.com/w_1200,h_630,c_fill/a.jpg"},
+		{"CDN transform then a list", "https://cdn.example.com/w_100,h_100/a.jpg, https://cdn.example.com/b.jpg", "https://cdn.example.com/w_100,h_100/a.jpg"},
+		{"comma in the query", "https://cdn.example.com/a.jpg?size=1200,630", "https://cdn.example.com/a.jpg?size=1200,630"},
+		{"unencoded space", "https://cdn.example.com/summer sale.jpg", "https://cdn.example.com/summer sale.jpg"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := firstURL(tt.value); got != tt.want {
+				t.Errorf("firstURL(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOGCharset(t *testing.T) {
+	// "유니굿즈 스티커" in EUC-KR
+	const eucKR = "\xc0\xaf\xb4\xcf\xb1\xc2\xc1\xee \xbd\xba\xc6\xbc\xc4\xbf"
+	tests := []struct {
+		name        string
+		contentType string
+		page        string
+	}{
+		{"content-type header", "text/html; charset=euc-kr", `<meta property="og:title" content="` + eucKR + `">`},
+		{"meta charset", "text/html", `<meta charset="euc-kr"><meta property="og:title" content="` + eucKR + `">`},
+		{"http-equiv", "", `<meta http-equiv="Content-Type" content="text/html; charset=EUC-KR"><meta property="og:title" content="` + eucKR + `">`},
+		{"utf-8", "text/html; charset=utf-8", `<meta property="og:title" content="유니굿즈 스티커">`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			og, err := parseOG(strings.NewReader(tt.page), tt.contentType, "https://example.com/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if og.Title != "유니굿즈 스티커" {
+				t.Errorf("title = %q, want %q", og.Title, "유니굿즈 스티커")
+			}
+		})
+	}
+}
+
+func TestParseOGEmptyBody(t *testing.T) {
+	for _, contentType := range []string{"text/html", "text/html; charset=euc-kr", ""} {
+		og, err := parseOG(strings.NewReader(""), contentType, "https://example.com/")
+		if err != nil || !reflect.DeepEqual(og, OG{}) {
+			t.Errorf("parseOG(empty, %q) = %+v, %v; want an empty OG and no error", contentType, og, err)
+		}
+	}
+}
+
+func TestFallbackImage(t *testing.T) {
+	tests := []struct {
+		name string
+		og   OG
+		want string
+	}{
+		{"touch icon first", OG{TouchIcon: "/t.png", Favicon: "/f.png", BodyImage: "/b.jpg"}, "/t.png"},
+		{"then favicon", OG{Favicon: "/f.png", BodyImage: "/b.jpg"}, "/f.png"},
+		{"then body image", OG{BodyImage: "/b.jpg"}, "/b.jpg"},
+		{"none", OG{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.og.fallbackImage(); got != tt.want {
+				t.Errorf("fallbackImage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// largePage is a product page with a complete <head> followed by a 4 MiB
+// body, like the long catalog pages some shops serve.
+func largePage(head string) string {
+	var sb strings.Builder
+	sb.WriteString("<!doctype html><html><head><title>Doc</title>" + head + "</head><body>")
+	for sb.Len() < 4<<20 {
+		sb.WriteString(`<div class="item"><img src="/p.jpg" width="300"><p>Lorem ipsum dolor sit amet</p></div>`)
+	}
+	sb.WriteString("</body></html>")
+	return sb.String()
+}
+
+func TestParseOGStopsEarly(t *testing.T) {
+	const complete = `<meta property="og:title" content="T"><meta property="og:description" content="D"><meta property="og:image" content="https://example.com/i.jpg">`
+	tests := []struct {
+		name      string
+		page      string
+		want      OG
+		wantEarly bool
+	}{
+		{
+			name:      "complete head",
+			page:      largePage(complete),
+			want:      OG{Title: "T", Description: "D", Image: "https://example.com/i.jpg"},
+			wantEarly: true,
+		},
+		{
+			name: "missing image reads the body for fallbacks",
+			page: largePage(`<meta property="og:title" content="T"><meta property="og:description" content="D">`),
+			want: OG{Title: "T", Description: "D", BodyImage: "/p.jpg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &countingReader{r: strings.NewReader(tt.page)}
+			og, err := parseOG(cr, "text/html; charset=utf-8", "https://example.com/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(og, tt.want) {
+				t.Errorf("parseOG() = %+v, want %+v", og, tt.want)
+			}
+			if early := cr.n < 64<<10; early != tt.wantEarly {
+				t.Errorf("read %d of %d bytes, stopped early %v, want %v", cr.n, len(tt.page), early, tt.wantEarly)
+			}
+		})
+	}
+}
+
+func BenchmarkParseOG(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		head string
+	}{
+		{"complete head", `<meta property="og:title" content="T"><meta property="og:description" content="D"><meta property="og:image" content="https://example.com/i.jpg">`},
+		{"incomplete head", `<meta property="og:title" content="T">`},
+	}
+	for _, bm := range benchmarks {
+		page := largePage(bm.head)
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(page)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := parseOG(strings.NewReader(page), "text/html; charset=utf-8", "https://example.com/"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
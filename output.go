@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// markerFile lists every file the previous run wrote, relative to the output
+// directory, so -clean only ever removes the tool's own output.
+const markerFile = ".generated"
+
+// output writes generated files under dir. In dry-run mode nothing touches
+// the filesystem and each file that would be written is logged instead.
+// Files whose content is already identical on disk are left alone so their
+// mtimes don't churn between runs. With diff set, a dry run collects a diff
+// of every change instead of logging the files.
+type output struct {
+	dir       string
+	dryRun    bool
+	written   int
+	unchanged int
+	files     []string
+	cleaned   bool
+
+	diff    bool
+	diffs   []string
+	removed []string
+
+	precompress []string // encodings for HTML siblings, see writeCompressed
+}
+
+// prepare makes sure dir is a writable directory, creating it if missing. In
+// dry-run mode it only checks that dir is not something else.
+func (o *output) prepare() error {
+	fi, err := os.Stat(o.dir)
+	switch {
+	case err == nil && !fi.IsDir():
+		return fmt.Errorf("-out %s exists and is not a directory", o.dir)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("-out %s: %w", o.dir, err)
+	case o.dryRun:
+		return nil
+	}
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		return fmt.Errorf("-out %s: %w", o.dir, err)
+	}
+	f, err := os.CreateTemp(o.dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("-out %s is not writable: %w", o.dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// write writes name and, for HTML files, its precompressed siblings.
+func (o *output) write(name string, data []byte) error {
+	if err := o.writeFile(name, data); err != nil {
+		return err
+	}
+	return o.writeCompressed(name, data)
+}
+
+func (o *output) writeFile(name string, data []byte) error {
+	path := filepath.Join(o.dir, name)
+	o.files = append(o.files, filepath.ToSlash(filepath.Clean(name)))
+	old, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(old, data) {
+		debugf("unchanged: %s", path)
+		o.unchanged++
+		return nil
+	}
+	if o.diff {
+		if err != nil {
+			old = nil
+		} else if old == nil {
+			old = []byte{}
+		}
+		if data == nil {
+			data = []byte{}
+		}
+		o.diffs = append(o.diffs, unifiedDiff(filepath.ToSlash(filepath.Clean(name)), old, data))
+		return nil
+	}
+	if o.dryRun {
+		infof("dry-run: would write %s (%d bytes)", path, len(data))
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return withCode(exitWrite, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return withCode(exitWrite, err)
+	}
+	o.written++
+	return nil
+}
+
+// clean removes the files recorded by the previous run's marker that this run
+// did not write, and any directories left empty by that, leaving unrelated
+// files in place. It runs after every write so a failed run loses nothing.
+func (o *output) clean() error {
+	o.cleaned = true
+	names, err := o.readMarker()
+	if err != nil {
+		return err
+	}
+	written := map[string]bool{}
+	for _, f := range o.files {
+		written[f] = true
+	}
+	var dirs []string
+	for _, name := range names {
+		if written[filepath.ToSlash(name)] {
+			continue
+		}
+		path := filepath.Join(o.dir, name)
+		if o.dryRun {
+			if o.diff {
+				o.removed = append(o.removed, name)
+			} else {
+				infof("dry-run: would remove %s", path)
+			}
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return withCode(exitWrite, err)
+		}
+		for d := filepath.Dir(name); d != "."; d = filepath.Dir(d) {
+			dirs = append(dirs, d)
+		}
+	}
+	if o.dryRun {
+		return nil
+	}
+	// deepest first so parents are empty by the time they are tried
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, d := range dirs {
+		// fails harmlessly on directories that still hold other files
+		os.Remove(filepath.Join(o.dir, d))
+	}
+	return nil
+}
+
+// printDiff writes the collected diffs, followed by files -clean would
+// remove without this run writing them again, and returns how many files
+// differ.
+func (o *output) printDiff(w io.Writer) int {
+	written := map[string]bool{}
+	for _, f := range o.files {
+		written[f] = true
+	}
+	diffs := o.diffs
+	for _, name := range o.removed {
+		name = filepath.ToSlash(name)
+		if written[name] {
+			continue
+		}
+		old, err := os.ReadFile(filepath.Join(o.dir, name))
+		if err != nil {
+			continue
+		}
+		diffs = append(diffs, unifiedDiff(name, old, nil))
+	}
+	for _, d := range diffs {
+		io.WriteString(w, d)
+	}
+	return len(diffs)
+}
+
+// readMarker returns the local paths listed in the marker file, if any.
+func (o *output) readMarker() ([]string, error) {
+	b, err := os.ReadFile(filepath.Join(o.dir, markerFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		name := filepath.Clean(filepath.FromSlash(strings.TrimSpace(sc.Text())))
+		if name != "." && filepath.IsLocal(name) {
+			names = append(names, name)
+		}
+	}
+	return names, sc.Err()
+}
+
+// writeMarker records the files written by this run for the next -clean.
+// Without -clean, files from earlier runs that still exist stay listed so a
+// later -clean can remove them too.
+func (o *output) writeMarker() error {
+	if o.dryRun {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, f := range o.files {
+		seen[f] = true
+	}
+	if !o.cleaned {
+		prev, err := o.readMarker()
+		if err != nil {
+			return err
+		}
+		for _, name := range prev {
+			if _, err := os.Stat(filepath.Join(o.dir, name)); err == nil {
+				seen[filepath.ToSlash(name)] = true
+			}
+		}
+	}
+	data := []byte(strings.Join(sortedKeys(seen), "\n") + "\n")
+	path := filepath.Join(o.dir, markerFile)
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	return withCode(exitWrite, os.WriteFile(path, data, 0644))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGenerateClean(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<meta property="og:title" content="P">`)
+	}))
+	defer srv.Close()
+	const (
+		withoutB = `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`
+		slowB    = `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b/c": "%[1]s/slow"}}`
+	)
+	everything := []string{".generated", ".git/HEAD", ".nojekyll", "CNAME", "a/index.html", "b/c/index.html", "b/keep.txt", "notes.txt"}
+	tests := []struct {
+		name    string
+		cfg     string // the second run's config; %[1]s is the test server's URL
+		opts    func(o *options)
+		wantErr bool
+		want    []string
+	}{
+		{
+			name: "stale routes stay without -clean",
+			cfg:  withoutB,
+			want: everything,
+		},
+		{
+			name: "clean",
+			cfg:  withoutB,
+			opts: func(o *options) { o.clean = true },
+			want: []string{".generated", ".git/HEAD", ".nojekyll", "CNAME", "a/index.html", "b/keep.txt", "notes.txt"},
+		},
+		{
+			name:    "clean keeps routes cut off by -deadline",
+			cfg:     slowB,
+			opts:    func(o *options) { o.clean, o.deadline = true, 300*time.Millisecond },
+			wantErr: true,
+			want:    everything,
+		},
+		{
+			name:    "clean removes nothing when the run fails",
+			cfg:     withoutB,
+			opts:    func(o *options) { o.clean, o.strict, o.pageBudget = true, true, 10 },
+			wantErr: true,
+			want:    everything,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, strings.ReplaceAll(`{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b/c": "%[1]s/p"}}`, "%[1]s", srv.URL))
+			if err := generate(context.Background(), o); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{".git/HEAD", "notes.txt", "b/keep.txt"} {
+				path := filepath.Join(o.outDir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte("keep\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(o.cfgPath, []byte(strings.ReplaceAll(tt.cfg, "%[1]s", srv.URL)), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.opts != nil {
+				tt.opts(o)
+			}
+			if err := generate(context.Background(), o); (err != nil) != tt.wantErr {
+				t.Fatalf("generate() = %v, want error %v", err, tt.wantErr)
+			}
+			if got := outFiles(t, o.outDir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+			if !tt.wantErr {
+				return
+			}
+			// the marker still lists the untouched page for the next -clean
+			marker, err := os.ReadFile(filepath.Join(o.outDir, markerFile))
+			if err != nil || !strings.Contains(string(marker), "b/c/index.html") {
+				t.Errorf("marker = %q, %v, want it to list b/c/index.html", marker, err)
+			}
+		})
+	}
+}
+
+func TestOutputWriteUnchanged(t *testing.T) {
+	tests := []struct {
+		name          string
+		existing      *string // nil for no file
+		data          string
+		wantWritten   int
+		wantUnchanged int
+	}{
+		{"new file", nil, "a", 1, 0},
+		{"same content", ptr("a"), "a", 0, 1},
+		{"new content", ptr("a"), "b", 1, 0},
+		{"empty", ptr(""), "", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &output{dir: t.TempDir()}
+			path := filepath.Join(o.dir, "CNAME")
+			if tt.existing != nil {
+				writeFile(t, path, *tt.existing)
+			}
+			if err := o.writeFile("CNAME", []byte(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			if o.written != tt.wantWritten || o.unchanged != tt.wantUnchanged {
+				t.Errorf("written %d, unchanged %d, want %d and %d", o.written, o.unchanged, tt.wantWritten, tt.wantUnchanged)
+			}
+			if b, err := os.ReadFile(path); err != nil || string(b) != tt.data {
+				t.Errorf("file = %q, %v, want %q", b, err, tt.data)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestGenerateTwice(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "defaultRedirect": "%[1]s/", "routes": {"/a": "%[1]s/p", "/b": "%[1]s/p"}}`, site.URL))
+	if err := generate(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, name := range outFiles(t, o.outDir) {
+		if err := os.Chtimes(filepath.Join(o.outDir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logs := captureLog(t)
+	if err := generate(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	if want := "0 files written, 5 unchanged"; !strings.Contains(logs.String(), want) {
+		t.Errorf("log does not contain %q:\n%s", want, logs)
+	}
+	for _, name := range outFiles(t, o.outDir) {
+		fi, err := os.Stat(filepath.Join(o.outDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(old) {
+			t.Errorf("%s was rewritten", name)
+		}
+	}
+}
+
+func TestOutputPrepare(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     func(t *testing.T) string
+		dryRun  bool
+		wantErr string // empty when dir ends up a directory
+	}{
+		{"existing", func(t *testing.T) string { return t.TempDir() }, false, ""},
+		{"missing", func(t *testing.T) string { return filepath.Join(t.TempDir(), "a", "b") }, false, ""},
+		{"missing in a dry run", func(t *testing.T) string { return filepath.Join(t.TempDir(), "a") }, true, ""},
+		{"file in the way", fileInTheWay, false, "exists and is not a directory"},
+		{"file in the way in a dry run", fileInTheWay, true, "exists and is not a directory"},
+		{"file above", func(t *testing.T) string { return filepath.Join(fileInTheWay(t), "out") }, false, "not a directory"},
+		{"read-only", readOnlyDir, false, "is not writable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tt.dir(t)
+			err := (&output{dir: dir, dryRun: tt.dryRun}).prepare()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), dir) {
+					t.Fatalf("prepare() = %v, want an error naming %s and containing %q", err, dir, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(dir); tt.dryRun != os.IsNotExist(err) {
+				t.Errorf("dry run %v, but stat(%s) = %v", tt.dryRun, dir, err)
+			}
+			if !tt.dryRun {
+				if left, _ := os.ReadDir(dir); len(left) != 0 {
+					t.Errorf("prepare() left %v behind", left)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateOutputFailsFast(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits.Add(1) }))
+	defer srv.Close()
+	o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%s/p"}}`, srv.URL))
+	o.outDir = fileInTheWay(t)
+	if err := generate(context.Background(), o); exitCode(err) != exitWrite {
+		t.Fatalf("generate() = %v with exit code %d, want %d", err, exitCode(err), exitWrite)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("fetched %d page(s) before failing", n)
+	}
+}
+
+// fileInTheWay returns the path of a regular file, so nothing can be
+// created at or below it.
+func fileInTheWay(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "out")
+	writeFile(t, path, "")
+	return path
+}
+
+// readOnlyDir returns a directory the test cannot create files in. Root
+// ignores permissions, so it gets a read-only /proc directory instead.
+func readOnlyDir(t *testing.T) string {
+	if os.Geteuid() != 0 {
+		dir := t.TempDir()
+		if err := os.Chmod(dir, 0555); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Chmod(dir, 0755) })
+		return dir
+	}
+	if fi, err := os.Stat("/proc/self"); err != nil || !fi.IsDir() {
+		t.Skip("no read-only directory available to root")
+	}
+	return "/proc/self"
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cmp"
+	_ "embed"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	//go:embed templates/redirect.html
+	redirectHTML string
+	//go:embed templates/page.html
+	defaultPageHTML string
+	//go:embed templates/404.html
+	default404HTML string
+)
+
+// redirectTemplate holds the "redirect" script shared by all page templates,
+// custom ones included.
+var redirectTemplate = template.Must(template.New("redirect.html").Parse(redirectHTML))
+
+var (
+	defaultPage = template.Must(parsePageTemplate("page", defaultPageHTML))
+	default404  = template.Must(parsePageTemplate("404", default404HTML))
+)
+
+func parsePageTemplate(name, text string) (*template.Template, error) {
+	t, err := redirectTemplate.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return t.New(name).Parse(text)
+}
+
+// pageData is what page templates render. html/template escapes every field
+// for its context, including .To inside the redirect <script>.
+type pageData struct {
+	Title       string
+	Description string
+	Image       string
+	ImageAlt    string
+	ImageWidth  int
+	ImageHeight int
+	SiteName    string
+	Locale      string
+	// LocaleAlternates are og:locale:alternate values, from the target's
+	// own tags and its hreflang alternates.
+	LocaleAlternates []string
+	Alternates       []Alternate
+	Video            string
+	VideoSecure      string // Video again when it is https, for og:video:secure_url
+	VideoType        string
+	VideoWidth       int
+	VideoHeight      int
+	Audio            string
+	AudioSecure      string
+	AudioType        string
+	PublishedTime    string
+	ModifiedTime     string
+	Authors          []string
+	TouchIcon        string
+	ThemeColor       string
+	ShopURL          string
+	To               string
+	Canonical        string
+	AlternateTo      string // rel=alternate to the target, with AlternateToTarget
+	Index            bool
+	Robots           string // robots meta content; empty for no tag
+	Delay            int
+	Analytics        Analytics
+	Wildcards        []wildcardRoute // 404 page only
+	// Variants replace To for visitors with JavaScript; the pick is kept in
+	// localStorage under VariantKey.
+	Variants   []Variant
+	VariantKey string
+	// Preconnect lists the origins the redirect may go to, so the browser
+	// can warm up the connection while the script runs.
+	Preconnect  []string
+	Brand       *Brand // nil unless the config sets one
+	HeadInclude template.HTML
+	BodyInclude template.HTML
+	BodyText    string
+	// WebManifest is the path of manifest.json, with -manifest-pwa only.
+	WebManifest string
+}
+
+// loadPageTemplate parses a user-supplied page template, or returns def
+// when path is empty.
+func loadPageTemplate(path string, def *template.Template) (*template.Template, error) {
+	if path == "" {
+		return def, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePageTemplate(filepath.Base(path), string(b))
+}
+
+// buildHTML renders the redirect page for r at path. r.To is the final
+// redirect target and og holds the already resolved preview values.
+func buildHTML(t *template.Template, cfg *Config, path string, r Route, og OG) (string, error) {
+	if t == nil {
+		t = defaultPage
+	}
+	data := pageData{
+		Title:         og.Title,
+		Description:   og.Description,
+		Image:         og.Image,
+		ImageAlt:      og.ImageAlt,
+		ImageWidth:    og.ImageWidth,
+		ImageHeight:   og.ImageHeight,
+		SiteName:      og.SiteName,
+		Locale:        cmp.Or(og.Locale, strings.TrimSpace(cfg.Locale), defaultLocale),
+		Alternates:    og.Alternates,
+		Video:         og.Video,
+		VideoType:     og.VideoType,
+		VideoWidth:    og.VideoWidth,
+		VideoHeight:   og.VideoHeight,
+		Audio:         og.Audio,
+		AudioType:     og.AudioType,
+		PublishedTime: og.PublishedTime,
+		ModifiedTime:  og.ModifiedTime,
+		Authors:       og.Authors,
+		TouchIcon:     cmp.Or(strings.TrimSpace(cfg.AppleTouchIcon), og.TouchIcon),
+		ThemeColor:    cmp.Or(strings.TrimSpace(cfg.ThemeColor), og.ThemeColor),
+		ShopURL:       cfg.pageURL(path, r),
+		To:            r.To,
+		Index:         r.Index,
+		Delay:         max(cfg.RedirectDelay, 0),
+		Analytics:     cfg.Analytics,
+	}
+	for _, v := range r.Variants {
+		data.Variants = append(data.Variants, Variant{To: cfg.withUTM(r, v.To), Weight: cmp.Or(v.Weight, 1)})
+	}
+	if len(data.Variants) > 0 {
+		data.VariantKey = "variant:" + publicRoutePath(path)
+	}
+	data.HeadInclude, data.BodyInclude, data.BodyText = includes(cfg)
+	if cfg.PWA != nil {
+		data.WebManifest = cfg.webManifestPath()
+	}
+	if cfg.Brand != (Brand{}) {
+		b := cfg.Brand
+		b.Text = cmp.Or(strings.TrimSpace(b.Text), "이동 중이에요…")
+		data.Brand = &b
+	}
+	data.Preconnect = appendOrigin(data.Preconnect, r.To)
+	for _, v := range data.Variants {
+		data.Preconnect = appendOrigin(data.Preconnect, v.To)
+	}
+	data.LocaleAlternates = localeAlternates(data.Locale, og)
+	if strings.HasPrefix(og.Video, "https:") {
+		data.VideoSecure = og.Video
+	}
+	if strings.HasPrefix(og.Audio, "https:") {
+		data.AudioSecure = og.Audio
+	}
+	data.Robots = strings.TrimSpace(r.Robots)
+	if data.Robots == "" && !r.Index {
+		data.Robots = "noindex"
+	}
+	data.Canonical = data.ShopURL
+	if r.Index {
+		data.Canonical = r.To
+	}
+	if cfg.AlternateToTarget && data.Canonical != r.To {
+		data.AlternateTo = r.To
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// appendOrigin adds the scheme://host origin of an http(s) target to
+// origins unless it is already listed.
+func appendOrigin(origins []string, target string) []string {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return origins
+	}
+	origin := u.Scheme + "://" + u.Host
+	if slices.Contains(origins, origin) {
+		return origins
+	}
+	return append(origins, origin)
+}
+
+// includes returns the config's page includes, the raw ones marked safe so
+// html/template inserts them as is.
+func includes(cfg *Config) (head, body template.HTML, text string) {
+	return template.HTML(cfg.HeadInclude), template.HTML(cfg.BodyInclude), strings.TrimSpace(cfg.BodyText)
+}
+
+const defaultLocale = "ko_KR"
+
+// localeAlternates lists the og:locale:alternate values for a page in
+// locale: og's own, then its hreflang languages turned into the ll_CC form,
+// without duplicates.
+func localeAlternates(locale string, og OG) []string {
+	seen := map[string]bool{strings.ToLower(locale): true}
+	var out []string
+	add := func(l string) {
+		if l != "" && !seen[strings.ToLower(l)] {
+			seen[strings.ToLower(l)] = true
+			out = append(out, l)
+		}
+	}
+	for _, l := range og.LocaleAlternates {
+		add(l)
+	}
+	for _, a := range og.Alternates {
+		if !strings.EqualFold(a.Lang, "x-default") {
+			add(strings.ReplaceAll(a.Lang, "-", "_"))
+		}
+	}
+	return out
+}
+
+// build404HTML renders the page for unknown paths. Unlike route pages it is
+// always noindex and has no canonical or og:url, since it stands for no URL
+// of its own. Static hosts serve it for every path without a file, so it
+// also carries the matcher for wildcard routes.
+func build404HTML(t *template.Template, cfg *Config, og OG) (string, error) {
+	if t == nil {
+		t = default404
+	}
+	data := pageData{
+		Title:       og.Title,
+		Description: og.Description,
+		Image:       og.Image,
+		To:          cfg.DefaultRedirect,
+		Delay:       max(cfg.RedirectDelay, 0),
+		Analytics:   cfg.Analytics,
+		Wildcards:   cfg.wildcards(),
+	}
+	data.HeadInclude, data.BodyInclude, data.BodyText = includes(cfg)
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,700 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBuildHTMLEscapesTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		to     string
+		wantJS string
+	}{
+		{"plain", "https://shop.example.com/p/1", `var to = "https://shop.example.com/p/1";`},
+		{
+			"script breakout",
+			`https://shop.example.com/?q="</script><script>alert(1)</script>`,
+			`var to = "https://shop.example.com/?q=\"\u003c/script\u003e\u003cscript\u003ealert(1)\u003c/script\u003e";`,
+		},
+		{"quote and backslash", `https://shop.example.com/it's\`, `var to = "https://shop.example.com/it's\\";`},
+	}
+	cfg := &Config{CNAME: "s.example.com"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := buildHTML(nil, cfg, "/a", Route{To: tt.to}, OG{Title: "t"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(page, tt.wantJS) {
+				t.Errorf("page does not contain %s:\n%s", tt.wantJS, page)
+			}
+			if strings.Contains(page, "<script>alert") {
+				t.Errorf("target broke out of the script:\n%s", page)
+			}
+		})
+	}
+}
+
+func TestBuildHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config // CNAME defaults to s.example.com
+		route   Route  // To defaults to https://shop.example.com/p
+		og      OG
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "meta refresh fallback",
+			want: []string{
+				`<noscript><meta http-equiv="refresh" content="0;url=https://shop.example.com/p"></noscript>`,
+				`<a href="https://shop.example.com/p">`,
+			},
+		},
+		{
+			name: "redirect delay",
+			cfg:  Config{RedirectDelay: 3},
+			want: []string{
+				`<meta http-equiv="refresh" content="3;url=https://shop.example.com/p">`,
+				`<span id="countdown">3</span>`,
+				`var delay =  3 ;`,
+			},
+		},
+		{
+			name:    "negative delay",
+			cfg:     Config{RedirectDelay: -2},
+			want:    []string{`content="0;url=`, `var delay =  0 ;`},
+			notWant: []string{`id="countdown"`},
+		},
+		{
+			name:    "noindex by default",
+			want:    []string{`<meta name="robots" content="noindex">`, `<link rel="canonical" href="https://s.example.com/a">`},
+			notWant: []string{`<link rel="canonical" href="https://shop.example.com/p">`},
+		},
+		{
+			name:    "indexable",
+			route:   Route{Index: true},
+			want:    []string{`<link rel="canonical" href="https://shop.example.com/p">`},
+			notWant: []string{`name="robots"`, `<link rel="canonical" href="https://s.example.com/a">`},
+		},
+		{
+			name: "image dimensions",
+			og:   OG{Image: "https://shop.example.com/a.jpg", ImageWidth: 1200, ImageHeight: 630},
+			want: []string{`<meta property="og:image:width" content="1200">`, `<meta property="og:image:height" content="630">`},
+		},
+		{
+			name:    "only one dimension",
+			og:      OG{Image: "https://shop.example.com/a.jpg", ImageWidth: 1200},
+			notWant: []string{"og:image:width", "og:image:height"},
+		},
+		{
+			name: "image alt",
+			og:   OG{Image: "https://shop.example.com/a.jpg", ImageAlt: "A red cap"},
+			want: []string{`<meta property="og:image:alt" content="A red cap">`},
+		},
+		{
+			name:    "no image alt",
+			og:      OG{Image: "https://shop.example.com/a.jpg"},
+			notWant: []string{"og:image:alt"},
+		},
+		{
+			name:    "no icon or theme color",
+			notWant: []string{"apple-touch-icon", "theme-color"},
+		},
+		{
+			name: "icon and theme color from the target",
+			og:   OG{TouchIcon: "https://shop.example.com/touch.png", ThemeColor: "#ff5500"},
+			want: []string{`<link rel="apple-touch-icon" href="https://shop.example.com/touch.png">`, `<meta name="theme-color" content="#ff5500">`},
+		},
+		{
+			name: "configured icon and theme color win",
+			cfg:  Config{AppleTouchIcon: " https://s.example.com/icon.png ", ThemeColor: "#000000"},
+			og:   OG{TouchIcon: "https://shop.example.com/touch.png", ThemeColor: "#ff5500"},
+			want: []string{`<link rel="apple-touch-icon" href="https://s.example.com/icon.png">`, `<meta name="theme-color" content="#000000">`},
+		},
+		{
+			name:    "default locale",
+			want:    []string{`<meta property="og:locale" content="ko_KR">`},
+			notWant: []string{"og:locale:alternate"},
+		},
+		{
+			name: "configured locale",
+			cfg:  Config{Locale: "en_US"},
+			want: []string{`<meta property="og:locale" content="en_US">`},
+		},
+		{
+			name: "locale from the target",
+			cfg:  Config{Locale: "en_US"},
+			og:   OG{Locale: "ja_JP", LocaleAlternates: []string{"ko_KR"}},
+			want: []string{`<meta property="og:locale" content="ja_JP">`, `<meta property="og:locale:alternate" content="ko_KR">`},
+		},
+		{
+			name:    "entities escaped once",
+			og:      OG{Title: "Tom & Jerry 감", Description: `"quoted" <b>`},
+			want:    []string{`<meta property="og:title" content="Tom &amp; Jerry 감">`, `<meta property="og:description" content="&#34;quoted&#34; &lt;b&gt;">`},
+			notWant: []string{"&amp;amp;"},
+		},
+		{
+			name:  "preconnect to the target origin",
+			route: Route{To: "https://shop.example.com:8443/p/1?x=1"},
+			want: []string{
+				`<link rel="preconnect" href="https://shop.example.com:8443">`,
+				`<link rel="dns-prefetch" href="https://shop.example.com:8443">`,
+			},
+			notWant: []string{`rel="preconnect" href="https://shop.example.com:8443/`},
+		},
+		{
+			name: "preconnect to each variant origin once",
+			route: Route{To: "https://shop.example.com/p", Variants: []Variant{
+				{To: "https://shop.example.com/p2"},
+				{To: "http://b.example.com/p"},
+			}},
+			want: []string{
+				`<link rel="preconnect" href="https://shop.example.com">
+<link rel="dns-prefetch" href="https://shop.example.com">
+<link rel="preconnect" href="http://b.example.com">
+<link rel="dns-prefetch" href="http://b.example.com">`,
+			},
+		},
+		{
+			name:    "no brand",
+			notWant: []string{`class="brand"`, `class="spinner"`, ".spinner{", "이동 중이에요"},
+		},
+		{
+			name: "brand",
+			cfg:  Config{Brand: Brand{Logo: "https://cdn.example.com/logo.png", Accent: "#ff5a00", Text: "유니굿즈로 이동 중"}},
+			want: []string{
+				"border-top-color:#ff5a00;",
+				`<div class="brand">
+<img src="https://cdn.example.com/logo.png" alt="">
+<div class="spinner"></div>
+<p>유니굿즈로 이동 중</p>
+</div>`,
+			},
+			notWant: []string{"이동 중이에요"},
+		},
+		{
+			name: "brand defaults",
+			cfg:  Config{Brand: Brand{Text: "  "}},
+			want: []string{"border-top-color:#111;", `<div class="spinner"></div>
+<p>이동 중이에요…</p>`},
+			notWant: []string{"<img"},
+		},
+		{
+			name: "brand with a countdown",
+			cfg:  Config{RedirectDelay: 2, Brand: Brand{Accent: "teal"}},
+			want: []string{`<p>이동 중이에요…</p>
+<p><span id="countdown">2</span>초 후 이동합니다.</p>
+</div>`},
+		},
+		{
+			name:    "hostile accent",
+			cfg:     Config{Brand: Brand{Accent: "red}</style><script>alert(1)</script>"}},
+			want:    []string{"border-top-color:ZgotmplZ;"},
+			notWant: []string{"<script>alert"},
+		},
+		{
+			name:    "robots override",
+			route:   Route{Robots: " noindex, nofollow "},
+			want:    []string{`<meta name="robots" content="noindex, nofollow">`},
+			notWant: []string{`content="noindex">`},
+		},
+		{
+			name:  "robots override on an indexed route",
+			route: Route{Index: true, Robots: "max-snippet:50, noarchive"},
+			want:  []string{`<meta name="robots" content="max-snippet:50, noarchive">`},
+		},
+		{
+			name:  "robots all",
+			route: Route{Robots: "all"},
+			want:  []string{`<meta name="robots" content="all">`},
+		},
+		{
+			// html/template writes "+" as &#43;, which parsers read back as "+"
+			name: "article",
+			og:   OG{PublishedTime: "2024-03-01T09:00:00+09:00", ModifiedTime: "2024-03-02T18:30:00+09:00", Authors: []string{"Kim", "Lee"}},
+			want: []string{`<meta property="og:type" content="article">
+<meta property="article:published_time" content="2024-03-01T09:00:00&#43;09:00">
+<meta property="article:modified_time" content="2024-03-02T18:30:00&#43;09:00">
+<meta property="article:author" content="Kim">
+<meta property="article:author" content="Lee">
+<meta property="og:title"`},
+			notWant: []string{`content="website"`},
+		},
+		{
+			name:    "article with only a published time",
+			og:      OG{PublishedTime: "2024-03-01"},
+			want:    []string{`<meta property="og:type" content="article">`, `<meta property="article:published_time" content="2024-03-01">`},
+			notWant: []string{"article:modified_time", "article:author"},
+		},
+		{
+			name:    "not an article",
+			want:    []string{`<meta property="og:type" content="website">`},
+			notWant: []string{"article"},
+		},
+		{
+			name: "video",
+			og:   OG{Video: "https://cdn.example.com/demo.mp4", VideoType: "video/mp4", VideoWidth: 1280, VideoHeight: 720, PublishedTime: "2024-03-01"},
+			want: []string{
+				`<meta property="og:type" content="video.other">`,
+				`<meta property="og:video" content="https://cdn.example.com/demo.mp4">
+<meta property="og:video:secure_url" content="https://cdn.example.com/demo.mp4">
+<meta property="og:video:type" content="video/mp4">
+<meta property="og:video:width" content="1280">
+<meta property="og:video:height" content="720">`,
+			},
+			notWant: []string{`content="article"`, `content="website"`},
+		},
+		{
+			name:    "http video",
+			og:      OG{Video: "http://cdn.example.com/demo.mp4", VideoWidth: 1280},
+			want:    []string{`<meta property="og:video" content="http://cdn.example.com/demo.mp4">`},
+			notWant: []string{"og:video:secure_url", "og:video:type", "og:video:width"},
+		},
+		{
+			name: "audio",
+			og:   OG{Audio: "https://cdn.example.com/song.mp3", AudioType: "audio/mpeg"},
+			want: []string{
+				`<meta property="og:type" content="website">`,
+				`<meta property="og:audio" content="https://cdn.example.com/song.mp3">
+<meta property="og:audio:secure_url" content="https://cdn.example.com/song.mp3">
+<meta property="og:audio:type" content="audio/mpeg">`,
+			},
+			notWant: []string{"og:video"},
+		},
+		{
+			name: "alternate to target",
+			cfg:  Config{AlternateToTarget: true},
+			want: []string{`<link rel="canonical" href="https://s.example.com/a">
+<link rel="alternate" href="https://shop.example.com/p">`},
+		},
+		{
+			name:    "no alternate by default",
+			want:    []string{`<link rel="canonical" href="https://s.example.com/a">`},
+			notWant: []string{`<link rel="alternate" href=`},
+		},
+		{
+			name:    "no alternate when the target is canonical",
+			cfg:     Config{AlternateToTarget: true},
+			route:   Route{Index: true},
+			want:    []string{`<link rel="canonical" href="https://shop.example.com/p">`},
+			notWant: []string{`<link rel="alternate" href=`},
+		},
+		{
+			name:  "alternate is escaped",
+			cfg:   Config{AlternateToTarget: true},
+			route: Route{To: `https://shop.example.com/p?a=1&b="x"`},
+			want:  []string{`<link rel="alternate" href="https://shop.example.com/p?a=1&amp;b=%22x%22">`},
+		},
+		{
+			name: "includes",
+			cfg: Config{
+				HeadInclude: `<meta name="google-site-verification" content="abc">`,
+				BodyInclude: `<script src="https://cdn.example.com/consent.js"></script>`,
+				BodyText:    ` 광고 포함 <b>링크</b> `,
+			},
+			want: []string{
+				`<meta name="google-site-verification" content="abc">
+</head>`,
+				`<p>광고 포함 &lt;b&gt;링크&lt;/b&gt;</p>
+<script src="https://cdn.example.com/consent.js"></script>
+</body>`,
+			},
+			notWant: []string{"<b>링크</b>"},
+		},
+		{
+			name:    "no includes",
+			notWant: []string{"google-site-verification", "consent.js", "<p></p>"},
+		},
+		{
+			name: "hreflang alternates",
+			og:   OG{Alternates: []Alternate{{Lang: "ko", Href: "https://shop.example.com/ko/p"}, {Lang: "en", Href: "https://shop.example.com/en/p"}}},
+			want: []string{
+				`<link rel="alternate" hreflang="ko" href="https://shop.example.com/ko/p">`,
+				`<link rel="alternate" hreflang="en" href="https://shop.example.com/en/p">`,
+			},
+		},
+		{
+			name:    "no analytics",
+			notWant: []string{"googletagmanager", "plausible"},
+		},
+		{
+			name: "GA4",
+			cfg:  Config{Analytics: Analytics{GA4: "G-TEST123"}},
+			want: []string{
+				`<script async src="https://www.googletagmanager.com/gtag/js?id=G-TEST123"></script>`,
+				`gtag("config", "G-TEST123", {send_page_view: false});`,
+				`waits++; gtag("event", "page_view", {event_callback: done, transport_type: "beacon"});`,
+			},
+			notWant: []string{"plausible"},
+		},
+		{
+			name: "Plausible",
+			cfg:  Config{Analytics: Analytics{Plausible: "s.example.com"}},
+			want: []string{
+				`<script defer data-domain="s.example.com" src="https://plausible.io/js/script.manual.js"></script>`,
+				`waits++; plausible("pageview", {callback: done});`,
+			},
+			notWant: []string{"googletagmanager"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg.CNAME == "" {
+				tt.cfg.CNAME = "s.example.com"
+			}
+			if tt.route.To == "" {
+				tt.route.To = "https://shop.example.com/p"
+			}
+			page, err := buildHTML(nil, &tt.cfg, "/a", tt.route, tt.og)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(page, w) {
+					t.Errorf("page does not contain %s", w)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(page, w) {
+					t.Errorf("page contains %s", w)
+				}
+			}
+			if t.Failed() {
+				t.Log(page)
+			}
+		})
+	}
+}
+
+// runRedirectScript runs the redirect script of page under node as if the
+// page were opened at href, and returns where it sent the browser. Timers
+// fire immediately. Tests using it are skipped without node on the PATH.
+func runRedirectScript(t *testing.T, page, href string) string {
+	t.Helper()
+	to, _ := runRedirectScriptWith(t, page, href, "")
+	return to
+}
+
+// runRedirectScriptWith runs setup before the page's script and returns where
+// it redirected to along with what it left in localStorage.
+func runRedirectScriptWith(t *testing.T, page, href, setup string) (string, map[string]string) {
+	t.Helper()
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not found")
+	}
+	start := strings.Index(page, "<script>(function(){")
+	end := strings.Index(page, "})();</script>")
+	if start < 0 || end < start {
+		t.Fatalf("no redirect script in page:\n%s", page)
+	}
+	script := page[start+len("<script>") : end+len("})();")]
+	stub := `var loc = new URL(` + strconv.Quote(href) + `), replaced = null, store = {};
+var window = {
+  location: {search: loc.search, hash: loc.hash, pathname: loc.pathname, replace: function(u){ replaced = u; }},
+  localStorage: {getItem: function(k){ return k in store ? store[k] : null; }, setItem: function(k, v){ store[k] = String(v); }},
+};
+var document = {getElementById: function(){ return null; }};
+var setTimeout = function(f){ f(); }, setInterval = function(){ return 0; }, clearInterval = function(){};
+`
+	out, err := exec.Command(node, "-e", stub+setup+"\n"+script+"\nconsole.log(JSON.stringify({to: replaced, store: store}));").CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	var res struct {
+		To    string            `json:"to"`
+		Store map[string]string `json:"store"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		t.Fatalf("node output %q: %v", out, err)
+	}
+	return res.To, res.Store
+}
+
+func TestRedirectScript(t *testing.T) {
+	tests := []struct {
+		name, to, href, want string
+	}{
+		{"no query", "https://shop.example.com/p", "https://s.example.com/a", "https://shop.example.com/p"},
+		{"query", "https://shop.example.com/p", "https://s.example.com/a?utm_source=ig", "https://shop.example.com/p?utm_source=ig"},
+		{"incoming wins", "https://shop.example.com/p?ref=x&utm_source=old", "https://s.example.com/a?utm_source=ig", "https://shop.example.com/p?ref=x&utm_source=ig"},
+		{"before the target's fragment", "https://shop.example.com/p#top", "https://s.example.com/a?q=1", "https://shop.example.com/p?q=1#top"},
+		{"fragment", "https://shop.example.com/p", "https://s.example.com/a#reviews", "https://shop.example.com/p#reviews"},
+		{"query and fragment", "https://shop.example.com/p", "https://s.example.com/a?q=1#reviews", "https://shop.example.com/p?q=1#reviews"},
+		{"target keeps its own fragment", "https://shop.example.com/p#top", "https://s.example.com/a#reviews", "https://shop.example.com/p#top"},
+	}
+	cfg := &Config{CNAME: "s.example.com"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := buildHTML(nil, cfg, "/a", Route{To: tt.to}, OG{Title: "t"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := runRedirectScript(t, page, tt.href); got != tt.want {
+				t.Errorf("redirected to %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectVariants(t *testing.T) {
+	variants := []Variant{{To: "https://shop.example.com/a1", Weight: 3}, {To: "https://shop.example.com/a2"}}
+	const key = "variant:/a"
+	tests := []struct {
+		name      string
+		variants  []Variant
+		random    float64 // what Math.random returns
+		stored    string  // localStorage value from an earlier visit
+		href      string
+		want      string
+		wantStore string
+	}{
+		// weights 3 and 1 split [0, 4) into [0, 3) and [3, 4)
+		{"low draw", variants, 0, "", "https://s.example.com/a", "https://shop.example.com/a1", "https://shop.example.com/a1"},
+		{"just below the split", variants, 0.74, "", "https://s.example.com/a", "https://shop.example.com/a1", "https://shop.example.com/a1"},
+		{"at the split", variants, 0.75, "", "https://s.example.com/a", "https://shop.example.com/a2", "https://shop.example.com/a2"},
+		{"high draw", variants, 0.99, "", "https://s.example.com/a", "https://shop.example.com/a2", "https://shop.example.com/a2"},
+		{"zero weight counts as one", []Variant{{To: "https://shop.example.com/a1"}, {To: "https://shop.example.com/a2"}}, 0.5, "", "https://s.example.com/a", "https://shop.example.com/a2", "https://shop.example.com/a2"},
+		{"return visit keeps the pick", variants, 0, "https://shop.example.com/a2", "https://s.example.com/a", "https://shop.example.com/a2", "https://shop.example.com/a2"},
+		{"stale pick is replaced", variants, 0, "https://shop.example.com/gone", "https://s.example.com/a", "https://shop.example.com/a1", "https://shop.example.com/a1"},
+		{"query forwarded", variants, 0.9, "", "https://s.example.com/a?utm_source=ig", "https://shop.example.com/a2?utm_source=ig", "https://shop.example.com/a2"},
+		{"no variants", nil, 0.9, "", "https://s.example.com/a", "https://shop.example.com/p", ""},
+	}
+	cfg := &Config{CNAME: "s.example.com"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := buildHTML(nil, cfg, "/a", Route{To: "https://shop.example.com/p", Variants: tt.variants}, OG{Title: "t"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the primary target stays what crawlers and the fallbacks see
+			if !strings.Contains(page, `<noscript><meta http-equiv="refresh" content="0;url=https://shop.example.com/p"></noscript>`) {
+				t.Errorf("meta refresh does not point at the primary target:\n%s", page)
+			}
+			setup := "Math.random = function(){ return " + strconv.FormatFloat(tt.random, 'f', -1, 64) + "; };"
+			if tt.stored != "" {
+				setup += "\nstore[" + strconv.Quote(key) + "] = " + strconv.Quote(tt.stored) + ";"
+			}
+			got, store := runRedirectScriptWith(t, page, tt.href, setup)
+			if got != tt.want {
+				t.Errorf("redirected to %q, want %q", got, tt.want)
+			}
+			if store[key] != tt.wantStore {
+				t.Errorf("localStorage[%q] = %q, want %q", key, store[key], tt.wantStore)
+			}
+		})
+	}
+}
+
+func TestLoadPageTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string // empty for the embedded default
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "default",
+			want: []string{
+				`<meta property="og:title" content="Tom &amp; Jerry">`,
+				`<link rel="canonical" href="https://s.example.com/a">`,
+			},
+		},
+		{
+			name:     "custom",
+			template: `<title>{{.Title}}</title><p>{{.Description}}</p><a href="{{.To}}">go</a>`,
+			want:     []string{`<title>Tom &amp; Jerry</title><p>&lt;b&gt;sale&lt;/b&gt;</p><a href="https://shop.example.com/p">go</a>`},
+		},
+		{
+			name:     "custom with the shared redirect",
+			template: `<h1>{{.Title}}</h1>{{template "redirect" .}}`,
+			want:     []string{`<h1>Tom &amp; Jerry</h1>`, `var to = "https://shop.example.com/p";`},
+		},
+		{"syntax error", `{{.Title`, nil, true},
+	}
+	cfg := &Config{CNAME: "s.example.com"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.template != "" {
+				path = filepath.Join(t.TempDir(), "page.html")
+				if err := os.WriteFile(path, []byte(tt.template), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tmpl, err := loadPageTemplate(path, defaultPage)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadPageTemplate() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			page, err := buildHTML(tmpl, cfg, "/a", Route{To: "https://shop.example.com/p"}, OG{Title: "Tom & Jerry", Description: "<b>sale</b>"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(page, w) {
+					t.Errorf("page does not contain %s:\n%s", w, page)
+				}
+			}
+		})
+	}
+	if _, err := loadPageTemplate(filepath.Join(t.TempDir(), "missing.html"), defaultPage); err == nil {
+		t.Error("loadPageTemplate() of a missing file succeeded")
+	}
+}
+
+func TestBuild404HTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config // CNAME defaults to s.example.com
+		template string // empty for the embedded default
+		want     []string
+		notWant  []string
+	}{
+		{
+			name: "default redirect",
+			cfg:  Config{DefaultRedirect: "https://shop.example.com/"},
+			want: []string{
+				`<meta name="robots" content="noindex">`,
+				`<noscript><meta http-equiv="refresh" content="0;url=https://shop.example.com/"></noscript>`,
+				`var to = "https://shop.example.com/";`,
+			},
+			notWant: []string{`rel="canonical"`, `og:url`},
+		},
+		{
+			name:    "no default redirect",
+			want:    []string{`<meta name="robots" content="noindex">`},
+			notWant: []string{`http-equiv="refresh"`, `rel="canonical"`},
+		},
+		{
+			name: "includes",
+			cfg: Config{
+				HeadInclude: `<meta name="google-site-verification" content="abc">`,
+				BodyInclude: `<script src="https://cdn.example.com/consent.js"></script>`,
+				BodyText:    `<i>문의</i>`,
+			},
+			want: []string{
+				`<meta name="google-site-verification" content="abc">
+</head>`,
+				`<p>&lt;i&gt;문의&lt;/i&gt;</p>
+<script src="https://cdn.example.com/consent.js"></script>
+</body>`,
+			},
+		},
+		{
+			name:     "custom template",
+			cfg:      Config{DefaultRedirect: "https://shop.example.com/"},
+			template: `<title>{{.Title}}</title><p>Not here. <a href="{{.To}}">Shop</a></p>`,
+			want:     []string{`<title>Not found</title><p>Not here. <a href="https://shop.example.com/">Shop</a></p>`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg.CNAME == "" {
+				tt.cfg.CNAME = "s.example.com"
+			}
+			tmpl := default404
+			if tt.template != "" {
+				path := filepath.Join(t.TempDir(), "404.html")
+				writeFile(t, path, tt.template)
+				var err error
+				if tmpl, err = loadPageTemplate(path, default404); err != nil {
+					t.Fatal(err)
+				}
+			}
+			page, err := build404HTML(tmpl, &tt.cfg, OG{Title: "Not found", Description: "d"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(page, w) {
+					t.Errorf("page does not contain %s", w)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(page, w) {
+					t.Errorf("page contains %s", w)
+				}
+			}
+			if t.Failed() {
+				t.Log(page)
+			}
+		})
+	}
+
+	// route pages carry exactly what the 404 page leaves out
+	page, err := buildHTML(nil, &Config{CNAME: "s.example.com"}, "/a", Route{To: "https://shop.example.com/p"}, OG{Title: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{`rel="canonical"`, `og:url`} {
+		if !strings.Contains(page, w) {
+			t.Errorf("route page does not contain %s", w)
+		}
+	}
+}
+
+func TestAppendOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		target  string
+		want    []string
+	}{
+		{"https", nil, "https://shop.example.com/p/1", []string{"https://shop.example.com"}},
+		{"http with port", nil, "http://shop.example.com:8080/p", []string{"http://shop.example.com:8080"}},
+		{"already listed", []string{"https://shop.example.com"}, "https://shop.example.com/q", []string{"https://shop.example.com"}},
+		{"scheme matters", []string{"https://shop.example.com"}, "http://shop.example.com/q", []string{"https://shop.example.com", "http://shop.example.com"}},
+		{"not http", nil, "mailto:shop@example.com", nil},
+		{"relative", nil, "/p/1", nil},
+		{"invalid", nil, "https://shop example.com/", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appendOrigin(tt.origins, tt.target); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("appendOrigin(%q, %q) = %q, want %q", tt.origins, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocaleAlternates(t *testing.T) {
+	tests := []struct {
+		name   string
+		locale string
+		og     OG
+		want   []string
+	}{
+		{"none", "ko_KR", OG{}, nil},
+		{"own tags", "ko_KR", OG{LocaleAlternates: []string{"en_US", "ja_JP"}}, []string{"en_US", "ja_JP"}},
+		{
+			name:   "from hreflang",
+			locale: "ko_KR",
+			og:     OG{Alternates: []Alternate{{Lang: "ko-KR"}, {Lang: "en-US"}, {Lang: "en"}, {Lang: "x-default"}}},
+			want:   []string{"en_US", "en"},
+		},
+		{
+			name:   "without duplicates",
+			locale: "ko_KR",
+			og:     OG{LocaleAlternates: []string{"en_US", "KO_KR"}, Alternates: []Alternate{{Lang: "en-us"}, {Lang: "ja-JP"}}},
+			want:   []string{"en_US", "ja_JP"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := localeAlternates(tt.locale, tt.og); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("localeAlternates() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// precompressors are the -precompress encodings by name, each with the
+// extension static hosts look for next to the original file.
+var precompressors = map[string]struct {
+	ext string
+	new func(io.Writer) io.WriteCloser
+}{
+	"gzip": {".gz", func(w io.Writer) io.WriteCloser {
+		zw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return zw
+	}},
+	"br": {".br", func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotli.BestCompression)
+	}},
+}
+
+// parsePrecompress turns a -precompress value such as "gzip,br" into its
+// encodings.
+func parsePrecompress(v string) ([]string, error) {
+	var encs []string
+	for _, e := range strings.Split(v, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if _, ok := precompressors[e]; !ok {
+			return nil, fmt.Errorf("unknown -precompress encoding %q (want gzip or br)", e)
+		}
+		encs = append(encs, e)
+	}
+	return encs, nil
+}
+
+// writeCompressed writes the precompressed siblings of an HTML file, e.g.
+// index.html.gz. An encoding that doesn't make the file smaller is skipped,
+// and a sibling left from an earlier run is removed so it can't go stale.
+func (o *output) writeCompressed(name string, data []byte) error {
+	if !strings.HasSuffix(name, ".html") {
+		return nil
+	}
+	for _, enc := range o.precompress {
+		c := precompressors[enc]
+		var b bytes.Buffer
+		w := c.new(&b)
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if b.Len() < len(data) {
+			if err := o.writeFile(name+c.ext, b.Bytes()); err != nil {
+				return err
+			}
+			continue
+		}
+		debugf("not precompressing %s with %s: no smaller", name, enc)
+		if !o.dryRun {
+			err := os.Remove(filepath.Join(o.dir, name+c.ext))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return withCode(exitWrite, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestParsePrecompress(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: ""},
+		{in: "gzip", want: []string{"gzip"}},
+		{in: "gzip,br", want: []string{"gzip", "br"}},
+		{in: " BR , gzip ,", want: []string{"br", "gzip"}},
+		{in: "zstd", wantErr: true},
+		{in: "gzip,deflate", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parsePrecompress(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePrecompress(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePrecompress(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteCompressed(t *testing.T) {
+	page := "<!doctype html>" + strings.Repeat("<p>유니굿즈 티셔츠</p>\n", 200)
+	tests := []struct {
+		name     string
+		file     string
+		data     string
+		encs     []string
+		stale    bool // siblings from an earlier run are on disk
+		dryRun   bool
+		wantExts []string
+	}{
+		{name: "gzip and brotli", file: "a/index.html", data: page, encs: []string{"gzip", "br"}, wantExts: []string{".gz", ".br"}},
+		{name: "gzip only", file: "a/index.html", data: page, encs: []string{"gzip"}, wantExts: []string{".gz"}},
+		{name: "no encodings", file: "a/index.html", data: page},
+		{name: "not HTML", file: "sitemap.xml", data: page, encs: []string{"gzip", "br"}},
+		{name: "no smaller", file: "a/index.html", data: "<p>", encs: []string{"gzip", "br"}},
+		{name: "no smaller removes stale siblings", file: "a/index.html", data: "<p>", encs: []string{"gzip", "br"}, stale: true},
+		{name: "stale siblings rewritten", file: "a/index.html", data: page, encs: []string{"gzip", "br"}, stale: true, wantExts: []string{".gz", ".br"}},
+		{name: "dry run", file: "a/index.html", data: page, encs: []string{"gzip", "br"}, dryRun: true},
+		{name: "dry run keeps stale siblings", file: "a/index.html", data: "<p>", encs: []string{"gzip", "br"}, stale: true, dryRun: true, wantExts: []string{".gz", ".br"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.stale {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, tt.file)), 0755); err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, filepath.Join(dir, tt.file+".gz"), "stale")
+				writeFile(t, filepath.Join(dir, tt.file+".br"), "stale")
+			}
+			o := &output{dir: dir, dryRun: tt.dryRun, precompress: tt.encs}
+			if err := o.write(tt.file, []byte(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			var gotExts []string
+			for _, ext := range []string{".gz", ".br"} {
+				if _, err := os.Stat(filepath.Join(dir, tt.file+ext)); err == nil {
+					gotExts = append(gotExts, ext)
+				}
+			}
+			if !reflect.DeepEqual(gotExts, tt.wantExts) {
+				t.Fatalf("siblings %q, want %q", gotExts, tt.wantExts)
+			}
+			if tt.dryRun {
+				return
+			}
+			for _, ext := range gotExts {
+				compressed, err := os.ReadFile(filepath.Join(dir, tt.file+ext))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(compressed) >= len(tt.data) {
+					t.Errorf("%s%s is %d bytes, not smaller than %d", tt.file, ext, len(compressed), len(tt.data))
+				}
+				if got := decompress(t, ext, compressed); got != tt.data {
+					t.Errorf("%s%s decompresses to %d bytes that differ from the %d written", tt.file, ext, len(got), len(tt.data))
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratePrecompress(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	o := testOptions(t, strings.ReplaceAll(`{"cname": "s.example.com", "defaultRedirect": "%[1]s/p", "routes": {"/a": "%[1]s/p", "/b": "%[1]s/p"}}`, "%[1]s", site.URL))
+	o.precompress = "gzip,br"
+	for run := 1; run <= 2; run++ {
+		if err := generate(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+		files := readOut(t, o.outDir)
+		var pages int
+		for name, content := range files {
+			if !strings.HasSuffix(name, ".html") {
+				if ext := filepath.Ext(name); (ext == ".gz" || ext == ".br") && !strings.HasSuffix(name, ".html"+ext) {
+					t.Errorf("run %d: compressed %s, which is not HTML", run, name)
+				}
+				continue
+			}
+			pages++
+			for _, ext := range []string{".gz", ".br"} {
+				compressed, ok := files[name+ext]
+				if !ok {
+					t.Errorf("run %d: no %s%s", run, name, ext)
+					continue
+				}
+				if got := decompress(t, ext, []byte(compressed)); got != content {
+					t.Errorf("run %d: %s%s does not decompress to %s", run, name, ext, name)
+				}
+			}
+		}
+		if pages == 0 {
+			t.Fatalf("run %d: no HTML written", run)
+		}
+	}
+}
+
+// decompress undoes the encoding a -precompress extension stands for.
+func decompress(t *testing.T, ext string, data []byte) string {
+	t.Helper()
+	var r io.Reader
+	switch ext {
+	case ".gz":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case ".br":
+		r = brotli.NewReader(bytes.NewReader(data))
+	default:
+		t.Fatalf("unknown extension %s", ext)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decompressing %s: %v", ext, err)
+	}
+	return string(b)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// progress counts finished OG fetches across the worker pool. On a terminal
+// it redraws a bar on stderr, which log lines clear and redraw around them;
+// otherwise each step is a debug line, so logs and CI output stay clean. A
+// nil *progress reports nothing.
+type progress struct {
+	mu    sync.Mutex // guards done without a bar; logger.mu does with one
+	total int
+	done  int
+	bar   io.Writer // nil when not drawing a bar
+}
+
+const progressWidth = 30
+
+func newProgress(total int) *progress {
+	p := &progress{total: total}
+	// the bar would garble JSON lines and has no place in quiet output
+	if logger.min == levelInfo && !logger.json && isTerminal(os.Stderr) {
+		p.bar = os.Stderr
+		logger.mu.Lock()
+		logger.bar = p
+		logger.mu.Unlock()
+	}
+	return p
+}
+
+// step records one finished fetch of target.
+func (p *progress) step(target string) {
+	if p == nil {
+		return
+	}
+	if p.bar == nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.done++
+		debugf("fetched %d/%d: %s", p.done, p.total, target)
+		return
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	p.done++
+	p.draw()
+	if p.done == p.total {
+		fmt.Fprintln(p.bar)
+		if logger.bar == p {
+			logger.bar = nil
+		}
+	}
+}
+
+// finish stops log lines from redrawing the bar once fetching is over,
+// ending the line of a bar that never got full.
+func (p *progress) finish() {
+	if p == nil || p.bar == nil {
+		return
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if logger.bar == p {
+		logger.bar = nil
+	}
+	if p.done > 0 && p.done < p.total {
+		fmt.Fprintln(p.bar)
+	}
+}
+
+// draw redraws the bar in place of the current line. The caller holds
+// logger.mu.
+func (p *progress) draw() {
+	n := progressWidth * p.done / max(p.total, 1)
+	fmt.Fprintf(p.bar, "\r\x1b[K[%s%s] %d/%d", strings.Repeat("=", n), strings.Repeat(" ", progressWidth-n), p.done, p.total)
+}
+
+// clear blanks the bar's line so a log line can take its place. The caller
+// holds logger.mu.
+func (p *progress) clear() {
+	fmt.Fprint(p.bar, "\r\x1b[K")
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int
+		bar     bool
+		wantEnd string // the last redraw, or the last debug line without a bar
+	}{
+		{"bar", 7, true, "\r\x1b[K[" + strings.Repeat("=", progressWidth) + "] 7/7\n"},
+		{"bar over many routes", 1000, true, "\r\x1b[K[" + strings.Repeat("=", progressWidth) + "] 1000/1000\n"},
+		{"counter", 7, false, "fetched 7/7: https://shop.example.com/p"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setLogging(t, true, false, false)
+			logs := captureLog(t)
+			var buf bytes.Buffer
+			p := &progress{total: tt.total}
+			if tt.bar {
+				p.bar = &buf
+			}
+			var wg sync.WaitGroup
+			for w := 0; w < 4; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := w; i < tt.total; i += 4 {
+						p.step("https://shop.example.com/p")
+					}
+				}()
+			}
+			wg.Wait()
+			if p.done != tt.total {
+				t.Fatalf("done = %d, want %d", p.done, tt.total)
+			}
+			if !tt.bar {
+				if n := strings.Count(logs.String(), "fetched "); n != tt.total {
+					t.Errorf("%d progress lines, want %d", n, tt.total)
+				}
+				if !strings.Contains(logs.String(), tt.wantEnd) {
+					t.Errorf("log does not contain %q:\n%s", tt.wantEnd, logs)
+				}
+				return
+			}
+			if n := strings.Count(buf.String(), "\r"); n != tt.total {
+				t.Errorf("%d redraws, want %d", n, tt.total)
+			}
+			if !strings.HasSuffix(buf.String(), tt.wantEnd) {
+				t.Errorf("bar ends in %q, want %q", buf.String()[max(buf.Len()-60, 0):], tt.wantEnd)
+			}
+			if strings.Contains(logs.String(), "fetched ") {
+				t.Errorf("counter logged while drawing a bar:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestProgressWithLogs(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps int // of 8
+	}{
+		{"complete", 8},
+		{"cut short", 5},
+		{"nothing fetched", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setLogging(t, false, false, false)
+			// the bar and the log share stderr
+			out := captureLog(t)
+			p := &progress{total: 8, bar: out}
+			logger.bar = p
+			t.Cleanup(func() { logger.bar = nil })
+			var wg sync.WaitGroup
+			for w := 0; w < 4; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := w; i < tt.steps; i += 4 {
+						warnf("retrying %d", i)
+						p.step("https://shop.example.com/p")
+					}
+				}()
+			}
+			wg.Wait()
+			p.finish()
+			warnf("after the bar")
+			if logger.bar != nil {
+				t.Error("bar still attached to the logger")
+			}
+			lines := strings.Split(out.String(), "\n")
+			if last := lines[len(lines)-1]; last != "" {
+				t.Errorf("output does not end in a newline: %q", last)
+			}
+			var warnings int
+			for _, line := range lines {
+				if !strings.Contains(line, "warn: ") {
+					continue
+				}
+				warnings++
+				// a warning replaces the bar on its line, never follows it
+				if tail := line[strings.LastIndex(line, "\x1b[K")+1:]; strings.Contains(tail, "] ") {
+					t.Errorf("warning printed after the bar: %q", line)
+				}
+			}
+			if warnings != tt.steps+1 {
+				t.Errorf("%d warnings, want %d:\n%q", warnings, tt.steps+1, out)
+			}
+			if strings.Contains(out.String(), "after the bar\n\r") {
+				t.Errorf("bar redrawn after finish:\n%q", out)
+			}
+		})
+	}
+}
+
+func TestNewProgress(t *testing.T) {
+	tests := []struct {
+		name                   string
+		verbose, quiet, asJSON bool
+	}{
+		{"default", false, false, false},
+		{"verbose", true, false, false},
+		{"quiet", false, true, false},
+		{"json", false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setLogging(t, tt.verbose, tt.quiet, tt.asJSON)
+			// a file is no terminal, so there is never a bar
+			stderr := captureOutput(t, &os.Stderr)
+			p := newProgress(3)
+			if p.bar != nil {
+				t.Fatal("drawing a bar on a file")
+			}
+			p.step("https://shop.example.com/p")
+			if out := stderr(); strings.Contains(out, "\x1b[K") {
+				t.Errorf("bar drawn to a file: %q", out)
+			}
+		})
+	}
+	var p *progress
+	p.step("https://shop.example.com/p") // a nil progress reports nothing
+}
+
+func TestFetchAllProgress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<meta property="og:title" content="%s">`, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		cancel bool
+	}{
+		{"fetched", false},
+		{"cancelled", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := make([]routeJob, 25)
+			for i := range jobs {
+				jobs[i].to = fmt.Sprintf("%s/p%d", srv.URL, i)
+			}
+			jobs[3].to = srv.URL + "/gone"
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			var buf bytes.Buffer
+			f := newFetcher(time.Second, "")
+			f.progress = &progress{total: len(jobs), bar: &buf}
+			f.fetchAll(ctx, jobs, 4)
+			if f.progress.done != len(jobs) {
+				t.Errorf("progress reached %d, want %d", f.progress.done, len(jobs))
+			}
+			if want := fmt.Sprintf("] %d/%d\n", len(jobs), len(jobs)); !strings.HasSuffix(buf.String(), want) {
+				t.Errorf("bar does not end in %q", want)
+			}
+		})
+	}
+}
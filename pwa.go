@@ -0,0 +1,70 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"strings"
+)
+
+// PWA configures the web app manifest written by -manifest-pwa, used when
+// the shop is saved to a home screen.
+type PWA struct {
+	Name            string    `json:"name,omitempty"`      // defaults to the CNAME
+	ShortName       string    `json:"shortName,omitempty"` // shown under the icon
+	Icons           []PWAIcon `json:"icons,omitempty"`
+	BackgroundColor string    `json:"backgroundColor,omitempty"`
+	ThemeColor      string    `json:"themeColor,omitempty"` // defaults to themeColor
+	Display         string    `json:"display,omitempty"`    // defaults to "browser"
+}
+
+// PWAIcon is one manifest icon, e.g. {"src": "/icon-192.png", "sizes": "192x192"}.
+type PWAIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// webManifest is the manifest.json format browsers read.
+type webManifest struct {
+	Name            string    `json:"name"`
+	ShortName       string    `json:"short_name,omitempty"`
+	StartURL        string    `json:"start_url"`
+	Display         string    `json:"display"`
+	BackgroundColor string    `json:"background_color,omitempty"`
+	ThemeColor      string    `json:"theme_color,omitempty"`
+	Icons           []PWAIcon `json:"icons,omitempty"`
+}
+
+// webManifestPath is where the web app manifest lives, under BasePath like
+// the route pages that link to it.
+func (c *Config) webManifestPath() string {
+	return c.routePath("/manifest.json")
+}
+
+// buildWebManifest renders manifest.json with the shop's base URL as the
+// start URL.
+func buildWebManifest(cfg *Config) (string, error) {
+	p := cfg.PWA
+	if p == nil {
+		p = &PWA{}
+	}
+	start := cfg.routePath("/")
+	if base := cfg.baseURL(); base != "" {
+		start = joinURL(base, start)
+	}
+	m := webManifest{
+		Name:            cmp.Or(strings.TrimSpace(p.Name), cfg.CNAME, "UniGoods"),
+		ShortName:       strings.TrimSpace(p.ShortName),
+		StartURL:        start,
+		Display:         cmp.Or(strings.TrimSpace(p.Display), "browser"),
+		BackgroundColor: strings.TrimSpace(p.BackgroundColor),
+		ThemeColor:      cmp.Or(strings.TrimSpace(p.ThemeColor), strings.TrimSpace(cfg.ThemeColor)),
+		Icons:           p.Icons,
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildWebManifest(t *testing.T) {
+	icons := []PWAIcon{{Src: "/icon-192.png", Sizes: "192x192", Type: "image/png"}, {Src: "/icon-512.png", Sizes: "512x512", Purpose: "maskable"}}
+	tests := []struct {
+		name string
+		cfg  Config
+		want webManifest
+	}{
+		{
+			name: "defaults",
+			cfg:  Config{CNAME: "s.example.com"},
+			want: webManifest{Name: "s.example.com", StartURL: "https://s.example.com/", Display: "browser"},
+		},
+		{
+			name: "every field",
+			cfg: Config{CNAME: "s.example.com", ThemeColor: "#000000", PWA: &PWA{
+				Name: " 유니굿즈 ", ShortName: "UniGoods", Icons: icons,
+				BackgroundColor: "#ffffff", ThemeColor: "#ff5a00", Display: "standalone",
+			}},
+			want: webManifest{
+				Name: "유니굿즈", ShortName: "UniGoods", StartURL: "https://s.example.com/", Display: "standalone",
+				BackgroundColor: "#ffffff", ThemeColor: "#ff5a00", Icons: icons,
+			},
+		},
+		{
+			name: "theme color from the config",
+			cfg:  Config{CNAME: "s.example.com", ThemeColor: "#000000", PWA: &PWA{}},
+			want: webManifest{Name: "s.example.com", StartURL: "https://s.example.com/", Display: "browser", ThemeColor: "#000000"},
+		},
+		{
+			name: "base URL",
+			cfg:  Config{CNAME: "s.example.com", BaseURL: "https://preview.example.com/", PWA: &PWA{}},
+			want: webManifest{Name: "s.example.com", StartURL: "https://preview.example.com/", Display: "browser"},
+		},
+		{
+			name: "base path",
+			cfg:  Config{CNAME: "s.example.com", BasePath: "/shop/", PWA: &PWA{}},
+			want: webManifest{Name: "s.example.com", StartURL: "https://s.example.com/shop", Display: "browser"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := buildWebManifest(&tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got webManifest
+			if err := json.Unmarshal([]byte(s), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("manifest = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateWebManifest(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	tests := []struct {
+		name     string
+		cfg      string // %[1]s is the test site's URL
+		pwa      bool
+		wantFile string // empty when no manifest is written
+		wantLink string
+	}{
+		{
+			name:     "manifest",
+			cfg:      `{"cname": "s.example.com", "pwa": {"name": "UniGoods", "icons": [{"src": "/icon.png", "sizes": "192x192"}]}, "routes": {"/a": "%[1]s/p"}}`,
+			pwa:      true,
+			wantFile: "manifest.json",
+			wantLink: `<link rel="manifest" href="/manifest.json">`,
+		},
+		{
+			name:     "no pwa section",
+			cfg:      `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`,
+			pwa:      true,
+			wantFile: "manifest.json",
+			wantLink: `<link rel="manifest" href="/manifest.json">`,
+		},
+		{
+			name:     "base path",
+			cfg:      `{"cname": "s.example.com", "basePath": "shop", "routes": {"/a": "%[1]s/p"}}`,
+			pwa:      true,
+			wantFile: "shop/manifest.json",
+			wantLink: `<link rel="manifest" href="/shop/manifest.json">`,
+		},
+		{
+			name: "without -manifest-pwa",
+			cfg:  `{"cname": "s.example.com", "pwa": {"name": "UniGoods"}, "routes": {"/a": "%[1]s/p"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(tt.cfg, site.URL))
+			o.manifestPWA = tt.pwa
+			if err := generate(context.Background(), o); err != nil {
+				t.Fatal(err)
+			}
+			files := readOut(t, o.outDir)
+			var page string
+			for name, content := range files {
+				if strings.HasSuffix(name, "a/index.html") {
+					page = content
+				}
+			}
+			if tt.wantFile == "" {
+				for name := range files {
+					if strings.HasSuffix(name, "manifest.json") {
+						t.Errorf("wrote %s without -manifest-pwa", name)
+					}
+				}
+				if strings.Contains(page, `rel="manifest"`) {
+					t.Errorf("page links a manifest without -manifest-pwa:\n%s", page)
+				}
+				return
+			}
+			var m webManifest
+			if err := json.Unmarshal([]byte(files[tt.wantFile]), &m); err != nil {
+				t.Fatalf("%s: %v", tt.wantFile, err)
+			}
+			if m.Name == "" || m.StartURL == "" || m.Display == "" {
+				t.Errorf("%s is incomplete: %+v", tt.wantFile, m)
+			}
+			if !strings.Contains(page, tt.wantLink) {
+				t.Errorf("page does not contain %s:\n%s", tt.wantLink, page)
+			}
+		})
+	}
+}
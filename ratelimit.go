@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// hostLimiter spaces requests to the same host at least interval apart,
+// across all workers. A nil limiter never waits.
+type hostLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newHostLimiter(perSecond float64) *hostLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &hostLimiter{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		next:     map[string]time.Time{},
+	}
+}
+
+// wait blocks until target's host may be requested again and reserves the
+// following slot, so concurrent callers queue up instead of bursting.
+func (l *hostLimiter) wait(ctx context.Context, target string) error {
+	if l == nil {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next[u.Host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[u.Host] = slot.Add(l.interval)
+	l.mu.Unlock()
+	return sleep(ctx, time.Until(slot))
+}
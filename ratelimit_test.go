@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		perSecond float64
+		targets   []string
+		min, max  time.Duration
+	}{
+		{"off", 0, []string{"https://a.example.com/1", "https://a.example.com/2", "https://a.example.com/3"}, 0, 20 * time.Millisecond},
+		{"same host", 20, []string{"https://a.example.com/1", "https://a.example.com/2", "https://a.example.com/3"}, 100 * time.Millisecond, 150 * time.Millisecond},
+		{"hosts are separate", 20, []string{"https://a.example.com/", "https://b.example.com/", "https://c.example.com/"}, 0, 20 * time.Millisecond},
+		{"port is part of the host", 20, []string{"http://a.example.com:8080/", "http://a.example.com:8081/"}, 0, 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newHostLimiter(tt.perSecond)
+			start := time.Now()
+			var wg sync.WaitGroup
+			for _, target := range tt.targets {
+				wg.Add(1)
+				go func(target string) {
+					defer wg.Done()
+					if err := l.wait(context.Background(), target); err != nil {
+						t.Error(err)
+					}
+				}(target)
+			}
+			wg.Wait()
+			if d := time.Since(start); d < tt.min || d > tt.max {
+				t.Errorf("waited %v, want between %v and %v", d, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestHostLimiterCancel(t *testing.T) {
+	l := newHostLimiter(0.1)
+	if err := l.wait(context.Background(), "https://a.example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, "https://a.example.com/"); err == nil {
+		t.Error("wait() on a cancelled context = nil, want an error")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// sortedRoutes returns the route keys of cfg in lexical order.
+func sortedRoutes(cfg *Config) []string {
+	return sortedKeys(cfg.Routes)
+}
+
+// publicPath is routePath for use in URLs, where the root is "/" not "".
+func publicPath(cfg *Config, p string) string {
+	return publicRoutePath(cfg.routePath(p))
+}
+
+func publicRoutePath(rp string) string {
+	if rp == "" {
+		return "/"
+	}
+	return rp
+}
+
+// buildNetlifyRedirects emits a Netlify _redirects file with a 301 per route.
+// DefaultRedirect becomes a trailing 302 catch-all, since Netlify applies the
+// first matching rule and the default target may change.
+func buildNetlifyRedirects(cfg *Config) string {
+	var sb strings.Builder
+	for _, p := range cfg.redirectOrder() {
+		r := cfg.Routes[p]
+		to := cfg.withUTM(r, r.To)
+		if _, ok := cfg.wildcardPrefix(p); ok {
+			to = forwardSuffix(to, ":splat")
+		}
+		fmt.Fprintf(&sb, "%s %s 301\n", publicPath(cfg, p), to)
+	}
+	if d := strings.TrimSpace(cfg.DefaultRedirect); d != "" {
+		fmt.Fprintf(&sb, "%s %s 302\n", publicPath(cfg, "/*"), d)
+	}
+	return sb.String()
+}
+
+type vercelRedirect struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Permanent   bool   `json:"permanent"`
+}
+
+// vercelSource translates a trailing "*" wildcard into Vercel's ":path*"
+// parameter and reports whether it did.
+func vercelSource(p string) (string, bool) {
+	if prefix, ok := strings.CutSuffix(p, "*"); ok {
+		return prefix + ":path*", true
+	}
+	return p, false
+}
+
+// vercelCatchAll is the source of the DefaultRedirect catch-all. Vercel
+// applies redirects before serving files, so the generated files in keep,
+// given as public paths, are excluded from it.
+func vercelCatchAll(cfg *Config, keep []string) string {
+	prefix := strings.TrimSuffix(publicPath(cfg, "/*"), "*")
+	var skip []string
+	for _, k := range keep {
+		if rel, ok := strings.CutPrefix(k, prefix); ok {
+			skip = append(skip, regexp.QuoteMeta(rel)+"$")
+		} else if k+"/" == prefix {
+			skip = append(skip, "$")
+		}
+	}
+	if len(skip) == 0 {
+		return prefix + ":path*"
+	}
+	return prefix + ":path((?!" + strings.Join(skip, "|") + ").*)"
+}
+
+// buildVercelConfig emits a vercel.json with a permanent redirect per route.
+// DefaultRedirect becomes a temporary catch-all that leaves the generated
+// files in keep alone.
+func buildVercelConfig(cfg *Config, keep []string) (string, error) {
+	redirects := []vercelRedirect{}
+	for _, p := range cfg.redirectOrder() {
+		src, wild := vercelSource(publicPath(cfg, p))
+		r := cfg.Routes[p]
+		to := cfg.withUTM(r, r.To)
+		if wild {
+			to = forwardSuffix(to, ":path*")
+		}
+		redirects = append(redirects, vercelRedirect{Source: src, Destination: to, Permanent: true})
+	}
+	if d := strings.TrimSpace(cfg.DefaultRedirect); d != "" {
+		redirects = append(redirects, vercelRedirect{Source: vercelCatchAll(cfg, keep), Destination: d})
+	}
+	b, err := json.MarshalIndent(struct {
+		Redirects []vercelRedirect `json:"redirects"`
+	}{redirects}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
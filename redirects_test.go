@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestBuildNetlifyRedirects(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{
+			name: "routes",
+			cfg: Config{Routes: map[string]Route{
+				"b": {To: "https://shop.example.com/b"},
+				"/": {To: "https://shop.example.com/"},
+				"a": {To: "https://shop.example.com/a"},
+			}},
+			want: "/ https://shop.example.com/ 301\n" +
+				"/a https://shop.example.com/a 301\n" +
+				"/b https://shop.example.com/b 301\n",
+		},
+		{
+			name: "default redirect is a trailing 302",
+			cfg: Config{DefaultRedirect: "https://shop.example.com", Routes: map[string]Route{
+				"a": {To: "https://shop.example.com/a"},
+			}},
+			want: "/a https://shop.example.com/a 301\n" +
+				"/* https://shop.example.com 302\n",
+		},
+		{
+			name: "base path",
+			cfg: Config{BasePath: "shop", DefaultRedirect: "https://shop.example.com", Routes: map[string]Route{
+				"a": {To: "https://shop.example.com/a"},
+			}},
+			want: "/shop/a https://shop.example.com/a 301\n" +
+				"/shop/* https://shop.example.com 302\n",
+		},
+		{
+			name: "wildcards after exact routes",
+			cfg: Config{Routes: map[string]Route{
+				"/p/*":     {To: "https://shop.example.com/products/?ref=sl"},
+				"/p/new/*": {To: "https://shop.example.com/new/"},
+				"/p/new":   {To: "https://shop.example.com/new-arrivals"},
+			}},
+			want: "/p/new https://shop.example.com/new-arrivals 301\n" +
+				"/p/new/* https://shop.example.com/new/:splat 301\n" +
+				"/p/* https://shop.example.com/products/:splat?ref=sl 301\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildNetlifyRedirects(&tt.cfg); got != tt.want {
+				t.Errorf("buildNetlifyRedirects() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildVercelConfig(t *testing.T) {
+	routes := map[string]Route{
+		"b": {To: "https://shop.example.com/b"},
+		"/": {To: "https://shop.example.com/"},
+		"a": {To: "https://shop.example.com/a"},
+	}
+	tests := []struct {
+		name   string
+		cfg    Config
+		keep   []string
+		golden string
+	}{
+		{"routes", Config{Routes: routes}, nil, "vercel.json"},
+		{
+			name:   "catch-all",
+			cfg:    Config{DefaultRedirect: "https://shop.example.com", Routes: routes},
+			golden: "vercel-catch-all.json",
+		},
+		{
+			name:   "catch-all keeps generated files",
+			cfg:    Config{DefaultRedirect: "https://shop.example.com", Routes: routes},
+			keep:   []string{"/sitemap.xml", "/robots.txt", "/manifest.json"},
+			golden: "vercel-keep.json",
+		},
+		{
+			name: "wildcards",
+			cfg: Config{Routes: map[string]Route{
+				"/p/*":   {To: "https://shop.example.com/products/?ref=sl"},
+				"/p/new": {To: "https://shop.example.com/new-arrivals"},
+			}},
+			golden: "vercel-wildcards.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildVercelConfig(&tt.cfg, tt.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("buildVercelConfig() =\n%s\nwant (%s)\n%s", got, golden, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+)
+
+// renderOptions is what renderRoute needs besides the config and the job.
+type renderOptions struct {
+	*options
+	tmpl     *template.Template // nil resolves the route without a page, e.g. for -format netlify
+	mirror   *imageMirror
+	verifier *imageVerifier
+}
+
+// renderedRoute is a route after renderRoute: the redirect target with its
+// UTM parameters, the OG data that went into the page, and the page itself.
+type renderedRoute struct {
+	to   string
+	og   OG
+	html string
+}
+
+// renderRoute runs the per-route pipeline shared by generate and serve:
+// overrides and fallbacks, image checks, and the page. A page over
+// -page-budget is an error under -strict and a warning otherwise.
+func renderRoute(cfg *Config, j routeJob, o renderOptions) (renderedRoute, error) {
+	routePath, to := j.path, j.to
+	og := j.route.applyOverrides(j.og)
+	base := to
+	if j.final != "" {
+		base = j.final
+		if o.resolveRedirects && j.err == nil && j.final != to {
+			infof("resolved %s: %s -> %s", routePath, to, j.final)
+			to = j.final
+		}
+	}
+	to = cfg.withUTM(j.route, to)
+	og = resolveOG(cfg, og, to, base)
+	// mirrored images are served from the site itself, so only an upgrade
+	// matters for them
+	if o.mirror == nil || o.upgradeImages {
+		og = insecureImage(cfg, og, routePath, o.upgradeImages)
+	}
+	og = o.verifier.apply(cfg, og, routePath)
+	if o.altFromTitle && og.Image != "" && og.ImageAlt == "" {
+		og.ImageAlt = og.Title
+	}
+	og.Description = truncateRunes(og.Description, o.maxDescription)
+	if o.mirror != nil && og.Image != "" {
+		if img, err := o.mirror.mirror(og.Image); err != nil {
+			warnf("not mirroring image for %s: %v", routePath, err)
+		} else {
+			og.Image = img.URL
+			if og.ImageWidth == 0 || og.ImageHeight == 0 {
+				og.ImageWidth, og.ImageHeight = img.Width, img.Height
+			}
+		}
+	}
+	rr := renderedRoute{to: to, og: og}
+	if o.tmpl == nil {
+		return rr, nil
+	}
+
+	r := j.route
+	r.To = to
+	page, err := buildHTML(o.tmpl, cfg, routePath, r, og)
+	if err != nil {
+		return rr, fmt.Errorf("route %s: %w", routePath, err)
+	}
+	if o.minify {
+		page = minifyHTML(page)
+	}
+	if o.pageBudget > 0 && len(page) > o.pageBudget {
+		err := fmt.Errorf("route %s: page is %d bytes, over the %d byte budget", routePath, len(page), o.pageBudget)
+		if o.strict {
+			return rr, withCode(exitConfig, err)
+		}
+		warnf("%v", err)
+	}
+	rr.html = page
+	return rr, nil
+}
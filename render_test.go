@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRenderRoute(t *testing.T) {
+	tests := []struct {
+		name     string
+		job      routeJob // path defaults to /a, to and route.To to https://shop.example.com/p
+		opts     func(*options)
+		noPage   bool
+		wantTo   string
+		wantOG   OG // only the fields set here are compared
+		wantAlt  string
+		wantErr  bool
+		wantCode int
+	}{
+		{
+			name:   "fallbacks",
+			wantTo: "https://shop.example.com/p",
+			wantOG: OG{Title: "UniGoods", Description: "UniGoods link", SiteName: "shop.example.com"},
+		},
+		{
+			name:   "overrides",
+			job:    routeJob{og: OG{Title: "Fetched"}, route: Route{Title: "Override"}},
+			wantTo: "https://shop.example.com/p",
+			wantOG: OG{Title: "Override"},
+		},
+		{
+			name:   "final URL is the image base",
+			job:    routeJob{og: OG{Image: "img.jpg"}, final: "https://shop.example.com/moved/"},
+			wantTo: "https://shop.example.com/p",
+			wantOG: OG{Image: "https://shop.example.com/moved/img.jpg"},
+		},
+		{
+			name:   "resolve redirects",
+			job:    routeJob{final: "https://shop.example.com/moved"},
+			opts:   func(o *options) { o.resolveRedirects = true },
+			wantTo: "https://shop.example.com/moved",
+		},
+		{
+			name:   "failed fetch keeps the target",
+			job:    routeJob{final: "https://shop.example.com/moved", err: errors.New("timeout")},
+			opts:   func(o *options) { o.resolveRedirects = true },
+			wantTo: "https://shop.example.com/p",
+		},
+		{
+			name:   "UTM",
+			job:    routeJob{route: Route{UTM: map[string]string{"utm_source": "ig"}}},
+			wantTo: "https://shop.example.com/p?utm_source=ig",
+		},
+		{
+			name:    "alt from title",
+			job:     routeJob{og: OG{Title: "Cap", Image: "https://shop.example.com/cap.jpg"}},
+			opts:    func(o *options) { o.altFromTitle = true },
+			wantTo:  "https://shop.example.com/p",
+			wantAlt: "Cap",
+		},
+		{
+			name:   "no alt without -alt-from-title",
+			job:    routeJob{og: OG{Title: "Cap", Image: "https://shop.example.com/cap.jpg"}},
+			wantTo: "https://shop.example.com/p",
+		},
+		{
+			name:    "alt from the target wins",
+			job:     routeJob{og: OG{Title: "Cap", Image: "https://shop.example.com/cap.jpg", ImageAlt: "A red cap"}},
+			opts:    func(o *options) { o.altFromTitle = true },
+			wantTo:  "https://shop.example.com/p",
+			wantAlt: "A red cap",
+		},
+		{
+			name:   "no alt without an image",
+			job:    routeJob{og: OG{Title: "Cap"}},
+			opts:   func(o *options) { o.altFromTitle = true },
+			wantTo: "https://shop.example.com/p",
+		},
+		{
+			name:   "description truncated",
+			job:    routeJob{og: OG{Description: "가나다라마바사"}},
+			opts:   func(o *options) { o.maxDescription = 4 },
+			wantTo: "https://shop.example.com/p",
+			wantOG: OG{Description: "가나다…"},
+		},
+		{
+			name:   "insecure image upgraded",
+			job:    routeJob{og: OG{Image: "http://cdn.example.com/a.jpg"}},
+			opts:   func(o *options) { o.upgradeImages = true },
+			wantTo: "https://shop.example.com/p",
+			wantOG: OG{Image: "https://cdn.example.com/a.jpg"},
+		},
+		{
+			name:   "insecure image kept",
+			job:    routeJob{og: OG{Image: "http://cdn.example.com/a.jpg"}},
+			wantTo: "https://shop.example.com/p",
+			wantOG: OG{Image: "http://cdn.example.com/a.jpg"},
+		},
+		{
+			name:   "no page",
+			noPage: true,
+			wantTo: "https://shop.example.com/p",
+		},
+		{
+			name:   "over budget",
+			opts:   func(o *options) { o.pageBudget = 100 },
+			wantTo: "https://shop.example.com/p",
+		},
+		{
+			name:     "over budget with -strict",
+			opts:     func(o *options) { o.pageBudget, o.strict = 100, true },
+			wantErr:  true,
+			wantCode: exitConfig,
+		},
+	}
+	cfg := &Config{CNAME: "s.example.com"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &options{maxDescription: 200, pageBudget: 16 << 10}
+			if tt.opts != nil {
+				tt.opts(o)
+			}
+			j := tt.job
+			if j.path == "" {
+				j.path = "/a"
+			}
+			if j.to == "" {
+				j.to = "https://shop.example.com/p"
+			}
+			if j.route.To == "" {
+				j.route.To = j.to
+			}
+			ro := renderOptions{options: o, tmpl: defaultPage}
+			if tt.noPage {
+				ro.tmpl = nil
+			}
+			rr, err := renderRoute(cfg, j, ro)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderRoute() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if exitCode(err) != tt.wantCode {
+					t.Errorf("exit code %d, want %d", exitCode(err), tt.wantCode)
+				}
+				return
+			}
+			if rr.to != tt.wantTo {
+				t.Errorf("to = %q, want %q", rr.to, tt.wantTo)
+			}
+			for _, f := range []struct{ name, got, want string }{
+				{"title", rr.og.Title, tt.wantOG.Title},
+				{"description", rr.og.Description, tt.wantOG.Description},
+				{"image", rr.og.Image, tt.wantOG.Image},
+				{"site name", rr.og.SiteName, tt.wantOG.SiteName},
+			} {
+				if f.want != "" && f.got != f.want {
+					t.Errorf("%s = %q, want %q", f.name, f.got, f.want)
+				}
+			}
+			if rr.og.ImageAlt != tt.wantAlt {
+				t.Errorf("image alt = %q, want %q", rr.og.ImageAlt, tt.wantAlt)
+			}
+			switch {
+			case tt.noPage && rr.html != "":
+				t.Errorf("page rendered without a template")
+			case !tt.noPage && !strings.Contains(rr.html, "window.location.replace(to)"):
+				t.Errorf("no redirect page rendered:\n%s", rr.html)
+			}
+		})
+	}
+}
+
+func TestPageBudget(t *testing.T) {
+	cfg := &Config{CNAME: "s.example.com"}
+	render := func(o *options, desc string) (renderedRoute, error) {
+		j := routeJob{path: "/a", to: "https://shop.example.com/p", route: Route{To: "https://shop.example.com/p", Description: desc}}
+		return renderRoute(cfg, j, renderOptions{options: o, tmpl: defaultPage})
+	}
+	tests := []struct {
+		name     string
+		desc     int // bytes of description override
+		budget   func(size int) int
+		strict   bool
+		wantWarn bool
+		wantErr  bool
+	}{
+		{"under budget", 100, func(n int) int { return n + 1 }, false, false, false},
+		{"exactly at budget", 100, func(n int) int { return n }, false, false, false},
+		{"no budget", 50000, func(int) int { return 0 }, true, false, false},
+		{"over budget", 2000, func(n int) int { return n - 1 }, false, true, false},
+		{"over budget with -strict", 2000, func(n int) int { return n - 1 }, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desc := strings.Repeat("a", tt.desc)
+			full, err := render(&options{}, desc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := len(full.html)
+			budget := tt.budget(size)
+			wantMsg := fmt.Sprintf("route /a: page is %d bytes, over the %d byte budget", size, budget)
+
+			logs := captureLog(t)
+			_, err = render(&options{pageBudget: budget, strict: tt.strict}, desc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderRoute() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if err.Error() != wantMsg || exitCode(err) != exitConfig {
+					t.Errorf("renderRoute() = %v (exit code %d), want %q (exit code %d)", err, exitCode(err), wantMsg, exitConfig)
+				}
+				return
+			}
+			if got := strings.Contains(logs.String(), "warn: "+wantMsg); got != tt.wantWarn {
+				t.Errorf("warned %v, want %v:\n%s", got, tt.wantWarn, logs)
+			}
+		})
+	}
+}
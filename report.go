@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// reportEntry holds per-route fetch diagnostics for -report.
+type reportEntry struct {
+	Path       string `json:"path"`
+	To         string `json:"to"`
+	FinalURL   string `json:"finalURL,omitempty"`
+	Status     int    `json:"status,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Bytes      int64  `json:"bytes"`
+	Cached     bool   `json:"cached,omitempty"`
+	// Fallbacks names the OG fields the target's page did not provide.
+	Fallbacks []string `json:"fallbacks,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func newReportEntry(j routeJob) reportEntry {
+	e := reportEntry{
+		Path:       publicRoutePath(j.path),
+		To:         j.to,
+		FinalURL:   j.final,
+		Status:     j.stats.status,
+		DurationMs: j.duration.Milliseconds(),
+		Bytes:      j.stats.bytes,
+		Cached:     j.stats.cached,
+	}
+	if j.err != nil {
+		e.Error = j.err.Error()
+	}
+	return e
+}
+
+// missingFields lists the fields of og (after route overrides) that resolveOG
+// has to fill in.
+func missingFields(og OG) []string {
+	var missing []string
+	for _, f := range []struct {
+		name, val string
+	}{
+		{"title", og.Title},
+		{"description", og.Description},
+		{"image", og.Image},
+		{"siteName", og.SiteName},
+	} {
+		if f.val == "" {
+			missing = append(missing, f.name)
+		}
+	}
+	return missing
+}
+
+func writeReport(path string, entries []reportEntry) error {
+	if entries == nil {
+		entries = []reportEntry{}
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMissingFields(t *testing.T) {
+	tests := []struct {
+		name string
+		og   OG
+		want []string
+	}{
+		{"complete", OG{Title: "t", Description: "d", Image: "i", SiteName: "s"}, nil},
+		{"empty", OG{}, []string{"title", "description", "image", "siteName"}},
+		{"image only", OG{Title: "t", Description: "d", SiteName: "s"}, []string{"image"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingFields(tt.og); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missingFields() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateReport(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/full", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<meta property="og:title" content="T"><meta property="og:description" content="D">
+			<meta property="og:image" content="/i.jpg"><meta property="og:site_name" content="S">`)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>Slow</title>`)
+	})
+	mux.Handle("/moved", http.RedirectHandler("/full", http.StatusMovedPermanently))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "routes": {
+		"/a": "%[1]s/full", "/b": "%[1]s/slow", "/c": "%[1]s/moved", "/d": {"to": "%[1]s/gone", "title": "Override"}}}`, srv.URL))
+	o.reportPath = filepath.Join(t.TempDir(), "report.json")
+	o.retries = 0
+	if err := generate(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(o.reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []reportEntry
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("%d entries, want 4:\n%s", len(got), b)
+	}
+	for _, e := range got {
+		if min := map[string]int64{"/b": 50}[e.Path]; e.DurationMs < min || e.DurationMs > 5000 {
+			t.Errorf("%s: implausible duration %dms", e.Path, e.DurationMs)
+		}
+		e.DurationMs = 0
+		if e.Error != "" {
+			e.Error = "set"
+		}
+		var want reportEntry
+		switch e.Path {
+		case "/a":
+			want = reportEntry{Path: "/a", To: srv.URL + "/full", FinalURL: srv.URL + "/full", Status: 200, Bytes: e.Bytes}
+		case "/b":
+			want = reportEntry{Path: "/b", To: srv.URL + "/slow", FinalURL: srv.URL + "/slow", Status: 200, Bytes: int64(len("<title>Slow</title>")),
+				Fallbacks: []string{"description", "image", "siteName"}}
+		case "/c":
+			want = reportEntry{Path: "/c", To: srv.URL + "/moved", FinalURL: srv.URL + "/full", Status: 200, Bytes: e.Bytes}
+		case "/d":
+			// the route's own title is no fallback
+			want = reportEntry{Path: "/d", To: srv.URL + "/gone", FinalURL: srv.URL + "/gone", Status: 404,
+				Fallbacks: []string{"description", "image", "siteName"}, Error: "set"}
+		}
+		if !reflect.DeepEqual(e, want) {
+			t.Errorf("entry = %+v, want %+v", e, want)
+		}
+		if e.Path != "/d" && e.Bytes == 0 {
+			t.Errorf("%s: no bytes counted", e.Path)
+		}
+	}
+}
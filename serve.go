@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// redirectServer answers route paths with real 302 redirects. The body still
+// carries the generated page so crawlers that don't follow redirects get the
+// OG preview.
+type redirectServer struct {
+	mu        sync.RWMutex
+	pages     map[string]servedPage
+	wildcards []servedWildcard
+	fallback  string
+}
+
+type servedPage struct {
+	to   string
+	html string
+}
+
+// servedWildcard answers paths under prefix with the page of the prefix
+// itself, redirecting to its target plus the rest of the path.
+type servedWildcard struct {
+	wildcardRoute
+	page servedPage
+}
+
+// serve runs -serve: it fetches OG data once, then serves redirects on
+// o.serve and reloads whenever the config or template changes, until ctx is
+// done.
+func serve(ctx context.Context, o *options) error {
+	s := &redirectServer{}
+	if err := s.load(ctx, o); err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: o.serve, Handler: s}
+	go func() {
+		err := watchFiles(ctx, o, func() {
+			infof("change detected, reloading")
+			if err := s.load(ctx, o); err != nil {
+				errorf("reload: %v (still serving the previous config)", err)
+			}
+		})
+		if err != nil {
+			errorf("watch: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	infof("serving redirects on %s", o.serve)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// load builds every route's page from o.cfgPath and swaps it in only once
+// everything succeeded.
+func (s *redirectServer) load(ctx context.Context, o *options) error {
+	if o.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.deadline)
+		defer cancel()
+	}
+	cfg, err := loadConfig(o.cfgPath, o.strictEnv)
+	if err != nil {
+		return err
+	}
+	if o.baseURL != "" {
+		cfg.BaseURL = o.baseURL
+	}
+	// only route pages are served, so there is no manifest.json to link
+	cfg.PWA = nil
+	pageTmpl, err := loadPageTemplate(o.templatePath, defaultPage)
+	if err != nil {
+		return err
+	}
+	if err := cfg.checkConfig(o); err != nil {
+		return err
+	}
+	if err := cfg.dropInvalid(o); err != nil {
+		return err
+	}
+	var cache *ogCache
+	if o.cachePath != "" {
+		if cache, err = loadCache(o.cachePath, o.cacheTTL); err != nil {
+			return err
+		}
+	}
+
+	jobs := make([]routeJob, 0, len(cfg.Routes))
+	for _, p := range cfg.pageRoutes() {
+		r := cfg.Routes[p]
+		jobs = append(jobs, routeJob{path: cfg.pagePath(p), to: r.To, route: r})
+	}
+	f := newFetcher(o.timeout, o.userAgent)
+	f.cache, f.retries, f.limit = cache, o.retries, newHostLimiter(o.rate)
+	if o.maxBody > 0 {
+		f.maxBody = o.maxBody
+	}
+	f.fetchAll(ctx, jobs, o.concurrency)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ro := renderOptions{options: o, tmpl: pageTmpl}
+	if o.verifyImages {
+		ro.verifier = newImageVerifier(ctx, f)
+	}
+	pages := make(map[string]servedPage, len(jobs))
+	for _, j := range jobs {
+		logRoute(j)
+		if j.err != nil {
+			warnf("OG fetch failed for %s: %v (using fallbacks)", j.to, j.err)
+		}
+		rendered, err := renderRoute(cfg, j, ro)
+		if err != nil {
+			return err
+		}
+		pages[publicRoutePath(j.path)] = servedPage{to: rendered.to, html: rendered.html}
+	}
+	if err := cache.save(); err != nil {
+		warnf("saving cache: %v", err)
+	}
+
+	var wildcards []servedWildcard
+	for _, w := range cfg.wildcards() {
+		page := pages[publicRoutePath(strings.TrimSuffix(w.Prefix, "/"))]
+		wildcards = append(wildcards, servedWildcard{wildcardRoute: w, page: page})
+	}
+
+	fallback := strings.TrimSpace(cfg.DefaultRedirect)
+
+	s.mu.Lock()
+	s.pages, s.wildcards, s.fallback = pages, wildcards, fallback
+	s.mu.Unlock()
+	infof("loaded %d routes", len(pages))
+	return nil
+}
+
+func (s *redirectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	page, ok := s.pages[publicRoutePath(cleanRoutePath(r.URL.Path))]
+	if !ok {
+		for _, wc := range s.wildcards {
+			if rest, found := strings.CutPrefix(r.URL.Path, wc.Prefix); found {
+				page, ok = wc.page, true
+				page.to = forwardSuffix(wc.To, rest)
+				break
+			}
+		}
+	}
+	fallback := s.fallback
+	s.mu.RUnlock()
+
+	if !ok {
+		if fallback == "" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, fallback, http.StatusFound)
+		return
+	}
+	w.Header().Set("Location", forwardQuery(page.to, r.URL.RawQuery))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusFound)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(page.html))
+	}
+}
+
+// forwardQuery merges the incoming query into to, incoming params winning on
+// conflict, like the generated pages' redirect script does.
+func forwardQuery(to, rawQuery string) string {
+	if rawQuery == "" {
+		return to
+	}
+	u, err := url.Parse(to)
+	if err != nil {
+		return to
+	}
+	in, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return to
+	}
+	q := u.Query()
+	for k, v := range in {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
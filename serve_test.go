@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForwardQuery(t *testing.T) {
+	tests := []struct {
+		name, to, query, want string
+	}{
+		{"no query", "https://shop.example.com/p?ref=x", "", "https://shop.example.com/p?ref=x"},
+		{"added", "https://shop.example.com/p", "utm_source=ig", "https://shop.example.com/p?utm_source=ig"},
+		{"merged", "https://shop.example.com/p?ref=x", "utm_source=ig", "https://shop.example.com/p?ref=x&utm_source=ig"},
+		{"incoming wins", "https://shop.example.com/p?utm_source=old", "utm_source=ig", "https://shop.example.com/p?utm_source=ig"},
+		{"fragment kept", "https://shop.example.com/p#top", "q=1", "https://shop.example.com/p?q=1#top"},
+		{"bad query", "https://shop.example.com/p", "a=%zz", "https://shop.example.com/p"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forwardQuery(tt.to, tt.query); got != tt.want {
+				t.Errorf("forwardQuery(%q, %q) = %q, want %q", tt.to, tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectServer(t *testing.T) {
+	site := newSite(t, map[string]string{
+		"/p":  `<meta property="og:title" content="Product">`,
+		"/p/": `<meta property="og:title" content="Products">`,
+	})
+	tests := []struct {
+		name         string
+		cfg          string // %[1]s is the test site's URL
+		method, path string
+		wantStatus   int
+		wantLocation string // %[1]s is the test site's URL
+		wantBody     string
+	}{
+		{
+			name:         "route",
+			cfg:          `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`,
+			method:       http.MethodGet,
+			path:         "/a",
+			wantStatus:   http.StatusFound,
+			wantLocation: "%[1]s/p",
+			wantBody:     `<meta property="og:title" content="Product">`,
+		},
+		{
+			name:         "trailing slash",
+			cfg:          `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`,
+			method:       http.MethodGet,
+			path:         "/a/",
+			wantStatus:   http.StatusFound,
+			wantLocation: "%[1]s/p",
+		},
+		{
+			name:         "root",
+			cfg:          `{"cname": "s.example.com", "routes": {"/": "%[1]s/p"}}`,
+			method:       http.MethodGet,
+			path:         "/",
+			wantStatus:   http.StatusFound,
+			wantLocation: "%[1]s/p",
+		},
+		{
+			name:         "query forwarded",
+			cfg:          `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p?ref=x"}}`,
+			method:       http.MethodGet,
+			path:         "/a?utm_source=ig",
+			wantStatus:   http.StatusFound,
+			wantLocation: "%[1]s/p?ref=x&utm_source=ig",
+		},
+		{
+			name:         "head",
+			cfg:          `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`,
+			method:       http.MethodHead,
+			path:         "/a",
+			wantStatus:   http.StatusFound,
+			wantLocation: "%[1]s/p",
+		},
+		{
+			name:         "wildcard",
+			cfg:          `{"cname": "s.example.com", "routes": {"/p/*": "%[1]s/p/?ref=sl"}}`,
+			method:       http.MethodGet,
+			path:         "/p/123?utm_source=ig",
+			wantStatus:   http.StatusFound,
+			wantLocation: "%[1]s/p/123?ref=sl&utm_source=ig",
+			wantBody:     `<meta property="og:title" content="Products">`,
+		},
+		{
+			name:         "exact route before a wildcard",
+			cfg:          `{"cname": "s.example.com", "routes": {"/p/*": "%[1]s/p/", "/p/new": "%[1]s/new"}}`,
+			method:       http.MethodGet,
+			path:         "/p/new",
+			wantStatus:   http.StatusFound,
+			wantLocation: "%[1]s/new",
+		},
+		{
+			name:         "longest wildcard first",
+			cfg:          `{"cname": "s.example.com", "routes": {"/p/*": "%[1]s/p/", "/p/new/*": "%[1]s/new/"}}`,
+			method:       http.MethodGet,
+			path:         "/p/new/9",
+			wantStatus:   http.StatusFound,
+			wantLocation: "%[1]s/new/9",
+		},
+		{
+			name:       "unknown",
+			cfg:        `{"cname": "s.example.com", "routes": {"/a": "%[1]s/p"}}`,
+			method:     http.MethodGet,
+			path:       "/b",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:         "default redirect",
+			cfg:          `{"cname": "s.example.com", "defaultRedirect": "%[1]s/home", "routes": {"/a": "%[1]s/p"}}`,
+			method:       http.MethodGet,
+			path:         "/b",
+			wantStatus:   http.StatusFound,
+			wantLocation: "%[1]s/home",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, fmt.Sprintf(tt.cfg, site.URL))
+			s := &redirectServer{}
+			if err := s.load(context.Background(), o); err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if want := fmt.Sprintf(tt.wantLocation, site.URL); tt.wantLocation != "" && rec.Header().Get("Location") != want {
+				t.Errorf("Location %q, want %q", rec.Header().Get("Location"), want)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body does not contain %s:\n%s", tt.wantBody, rec.Body)
+			}
+			if tt.method == http.MethodHead && rec.Body.Len() > 0 {
+				t.Errorf("HEAD response has a body")
+			}
+		})
+	}
+}
+
+func TestRedirectServerReload(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%s/p"}}`, site.URL))
+	s := &redirectServer{}
+	if err := s.load(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, o.cfgPath, `{"cname": "s.example.com", "routes": {"/a": "javascript:alert(1)"}}`)
+	if err := s.load(context.Background(), o); err == nil {
+		t.Fatal("load() of an invalid config succeeded")
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a", nil))
+	if got, want := rec.Header().Get("Location"), site.URL+"/p"; got != want {
+		t.Errorf("after a failed reload, Location %q, want the previous %q", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+)
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// buildSitemap lists every route under the shop URL. lastmod comes from the
+// OG cache when the target has a cached fetch, otherwise the current time.
+func buildSitemap(cfg *Config, cache *ogCache) string {
+	now := time.Now()
+	set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, p := range cfg.pageRoutes() {
+		mod := now
+		if t, ok := cache.fetchedAt(cfg.Routes[p].To); ok {
+			mod = t
+		}
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:     cfg.pageURL(cfg.pagePath(p), cfg.Routes[p]),
+			LastMod: mod.UTC().Format("2006-01-02"),
+		})
+	}
+	b, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return xml.Header + string(b) + "\n"
+}
+
+// buildRobots returns cfg.Robots (e.g. custom Disallow rules) followed by a
+// Sitemap line when a sitemap is written and the site has a base URL to
+// point at it. It returns "" when there is neither.
+func buildRobots(cfg *Config, sitemap bool) string {
+	var lines []string
+	if rules := strings.TrimSpace(cfg.Robots); rules != "" {
+		lines = append(lines, rules)
+	}
+	if base := cfg.baseURL(); sitemap && base != "" {
+		lines = append(lines, "Sitemap: "+joinURL(base, "sitemap.xml"))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n\n") + "\n"
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildSitemap(t *testing.T) {
+	today := time.Now().UTC().Format("2006-01-02")
+	fetched := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		cfg    Config
+		cached map[string]cacheEntry
+		want   []sitemapURL
+	}{
+		{
+			name: "sorted routes",
+			cfg: Config{CNAME: "s.example.com", Routes: map[string]Route{
+				"/b": {To: "https://shop.example.com/b"},
+				"/a": {To: "https://shop.example.com/a"},
+				"/":  {To: "https://shop.example.com/"},
+			}},
+			want: []sitemapURL{
+				{Loc: "https://s.example.com/", LastMod: today},
+				{Loc: "https://s.example.com/a", LastMod: today},
+				{Loc: "https://s.example.com/b", LastMod: today},
+			},
+		},
+		{
+			name: "lastmod from the cache",
+			cfg: Config{CNAME: "s.example.com", Routes: map[string]Route{
+				"/a": {To: "https://shop.example.com/a"},
+			}},
+			cached: map[string]cacheEntry{"https://shop.example.com/a": {FetchedAt: fetched}},
+			want:   []sitemapURL{{Loc: "https://s.example.com/a", LastMod: "2024-03-01"}},
+		},
+		{
+			name: "base path",
+			cfg: Config{CNAME: "s.example.com", BasePath: "shop", Routes: map[string]Route{
+				"/":  {To: "https://shop.example.com/"},
+				"/a": {To: "https://shop.example.com/a"},
+			}},
+			want: []sitemapURL{
+				{Loc: "https://s.example.com/shop", LastMod: today},
+				{Loc: "https://s.example.com/shop/a", LastMod: today},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cache *ogCache
+			if tt.cached != nil {
+				cache = &ogCache{entries: tt.cached}
+			}
+			var got sitemapURLSet
+			if err := xml.Unmarshal([]byte(buildSitemap(&tt.cfg, cache)), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Xmlns != "http://www.sitemaps.org/schemas/sitemap/0.9" {
+				t.Errorf("xmlns = %q", got.Xmlns)
+			}
+			if !reflect.DeepEqual(got.URLs, tt.want) {
+				t.Errorf("urls = %+v, want %+v", got.URLs, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildRobots(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		sitemap bool
+		want    string
+	}{
+		{"sitemap only", Config{CNAME: "s.example.com"}, true, "Sitemap: https://s.example.com/sitemap.xml\n"},
+		{"rules and sitemap", Config{CNAME: "s.example.com", Robots: "User-agent: *\nDisallow: /tmp/\n"}, true, "User-agent: *\nDisallow: /tmp/\n\nSitemap: https://s.example.com/sitemap.xml\n"},
+		{"rules without sitemap", Config{CNAME: "s.example.com", Robots: "User-agent: *"}, false, "User-agent: *\n"},
+		{"no base URL", Config{Robots: "User-agent: *"}, true, "User-agent: *\n"},
+		{"nothing to write", Config{CNAME: "s.example.com"}, false, ""},
+		{"base URL wins over CNAME", Config{CNAME: "s.example.com", BaseURL: "https://preview.example.com/"}, true, "Sitemap: https://preview.example.com/sitemap.xml\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildRobots(&tt.cfg, tt.sitemap); got != tt.want {
+				t.Errorf("buildRobots() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// validateTarget rejects redirect targets that are not absolute http(s) URLs,
+// e.g. javascript: or data: URLs.
+func validateTarget(to string) error {
+	u, err := url.Parse(to)
+	if err != nil {
+		return fmt.Errorf("invalid target %q: %w", to, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("target %q must be an http or https URL", to)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("target %q has no host", to)
+	}
+	return nil
+}
+
+// validateTarget checks to like the package-level validateTarget and, when
+// AllowedHosts is set, also requires its host to be listed there.
+func (c *Config) validateTarget(to string) error {
+	if err := validateTarget(to); err != nil {
+		return err
+	}
+	if len(c.AllowedHosts) == 0 {
+		return nil
+	}
+	u, _ := url.Parse(to)
+	if !hostAllowed(c.AllowedHosts, u.Hostname()) {
+		return fmt.Errorf("target %q: host %s is not in allowedHosts", to, u.Hostname())
+	}
+	return nil
+}
+
+// validateRoute checks the target, robots directive and variants of r.
+func (c *Config) validateRoute(r Route) error {
+	if err := c.validateTarget(r.To); err != nil {
+		return err
+	}
+	if err := validateRobots(r.Robots); err != nil {
+		return err
+	}
+	for i, v := range r.Variants {
+		if err := c.validateTarget(v.To); err != nil {
+			return fmt.Errorf("variant %d: %w", i+1, err)
+		}
+		if v.Weight < 0 {
+			return fmt.Errorf("variant %d: weight %v is negative", i+1, v.Weight)
+		}
+	}
+	return nil
+}
+
+// robotsTokens are the robots meta directives search engines document.
+// Directives taking a value, like max-snippet:50, are listed without it.
+var robotsTokens = map[string]bool{
+	"all": true, "none": true, "index": true, "noindex": true, "follow": true,
+	"nofollow": true, "noarchive": true, "nocache": true, "nosnippet": true,
+	"noimageindex": true, "notranslate": true, "indexifembedded": true,
+	"max-snippet": true, "max-image-preview": true, "max-video-preview": true,
+	"unavailable_after": true,
+}
+
+// validateRobots rejects a robots directive with unknown tokens, which
+// crawlers would silently ignore.
+func validateRobots(robots string) error {
+	if strings.TrimSpace(robots) == "" {
+		return nil
+	}
+	for _, tok := range strings.Split(robots, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(tok), ":")
+		if !robotsTokens[strings.ToLower(name)] {
+			return fmt.Errorf("robots %q: unknown directive %q", robots, strings.TrimSpace(tok))
+		}
+	}
+	return nil
+}
+
+// hostAllowed matches host against allowed entries case-insensitively. An
+// entry with a leading dot also matches every subdomain of it.
+func hostAllowed(allowed []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if suffix, ok := strings.CutPrefix(a, "."); ok {
+			if host == suffix || strings.HasSuffix(host, a) {
+				return true
+			}
+		} else if host == a {
+			return true
+		}
+	}
+	return false
+}
+
+// routeCollisions reports route keys that normalize to the same output path,
+// e.g. "/a", "/a/" and "a".
+func routeCollisions(cfg *Config) []error {
+	byPath := map[string][]string{}
+	for _, p := range sortedRoutes(cfg) {
+		rp := publicPath(cfg, p)
+		byPath[rp] = append(byPath[rp], p)
+	}
+	var errs []error
+	for _, rp := range sortedKeys(byPath) {
+		if keys := byPath[rp]; len(keys) > 1 {
+			errs = append(errs, fmt.Errorf("routes %q all map to %s", keys, rp))
+		}
+	}
+	return errs
+}
+
+// mergeRouteCollisions keeps one route per output path. When several keys
+// normalize to the same path the last one in sorted order wins under its own
+// key, and each dropped key is logged.
+func mergeRouteCollisions(cfg *Config) {
+	winner := map[string]string{}
+	for _, p := range sortedRoutes(cfg) {
+		rp := publicPath(cfg, p)
+		if prev, ok := winner[rp]; ok {
+			warnf("route %q overrides %q (both map to %s)", p, prev, rp)
+		}
+		winner[rp] = p
+	}
+	merged := make(map[string]Route, len(winner))
+	for _, p := range winner {
+		merged[p] = cfg.Routes[p]
+	}
+	cfg.Routes = merged
+}
+
+// errNoRoutes guards against deploying a config without routes, usually a
+// typo or the wrong file, unless -allow-empty says that is intended.
+var errNoRoutes = errors.New("routes is empty or missing (use -allow-empty if that is intended)")
+
+// errNoBaseURL guards against pages whose canonical, og:url and sitemap
+// links would be relative, which crawlers and link previews ignore.
+var errNoBaseURL = errors.New("neither baseURL nor cname is set (set one or pass -base-url)")
+
+// validateConfig checks cfg without fetching anything and returns one error
+// per problem found.
+func validateConfig(cfg *Config, allowEmpty bool) []error {
+	var errs []error
+	if len(cfg.Routes) == 0 && !allowEmpty {
+		errs = append(errs, errNoRoutes)
+	}
+	if cfg.baseURL() == "" {
+		errs = append(errs, errNoBaseURL)
+	}
+	for _, p := range sortedRoutes(cfg) {
+		to := strings.TrimSpace(cfg.Routes[p].To)
+		if to == "" {
+			errs = append(errs, fmt.Errorf("route %s: empty target", p))
+			continue
+		}
+		if err := cfg.validateRoute(cfg.Routes[p]); err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", p, err))
+		}
+	}
+	if d := strings.TrimSpace(cfg.DefaultRedirect); d != "" {
+		if err := cfg.validateTarget(d); err != nil {
+			errs = append(errs, fmt.Errorf("defaultRedirect: %w", err))
+		}
+	}
+	return append(errs, routeCollisions(cfg)...)
+}
+
+// checkConfig requires a base URL, applies -allow-empty and
+// -merge-duplicates and rejects route collisions, before generate or serve
+// fetch anything.
+func (c *Config) checkConfig(o *options) error {
+	if c.baseURL() == "" {
+		return withCode(exitConfig, fmt.Errorf("%s: %w", o.cfgPath, errNoBaseURL))
+	}
+	if len(c.Routes) == 0 && !o.allowEmpty {
+		return withCode(exitConfig, fmt.Errorf("%s: %w", o.cfgPath, errNoRoutes))
+	}
+	// the map keys "/a", "/a/" and "a" would otherwise silently overwrite
+	// each other's output
+	if o.mergeDuplicates {
+		mergeRouteCollisions(c)
+	}
+	if errs := routeCollisions(c); len(errs) > 0 {
+		for _, err := range errs {
+			errorf("%v", err)
+		}
+		return withCode(exitConfig, fmt.Errorf("%d route collision(s) in %s", len(errs), o.cfgPath))
+	}
+	return nil
+}
+
+// dropInvalid rejects the first invalid route or defaultRedirect, or with
+// -skip-invalid drops every invalid one with a warning. Like a config without
+// routes, dropping them all is an error unless -allow-empty is set.
+func (c *Config) dropInvalid(o *options) error {
+	for _, p := range sortedRoutes(c) {
+		if err := c.validateRoute(c.Routes[p]); err != nil {
+			if !o.skipInvalid {
+				return withCode(exitConfig, fmt.Errorf("route %s: %w", p, err))
+			}
+			warnf("skipping route %s: %v", p, err)
+			delete(c.Routes, p)
+		}
+	}
+	if strings.TrimSpace(c.DefaultRedirect) != "" {
+		if err := c.validateTarget(c.DefaultRedirect); err != nil {
+			if !o.skipInvalid {
+				return withCode(exitConfig, fmt.Errorf("defaultRedirect: %w", err))
+			}
+			warnf("skipping defaultRedirect: %v", err)
+			c.DefaultRedirect = ""
+		}
+	}
+	if len(c.Routes) == 0 && !o.allowEmpty {
+		return withCode(exitConfig, fmt.Errorf("%s: every route was skipped as invalid: %w", o.cfgPath, errNoRoutes))
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateTarget(t *testing.T) {
+	tests := []struct {
+		to      string
+		wantErr bool
+	}{
+		{"https://shop.example.com/p/1", false},
+		{"http://shop.example.com", false},
+		{"HTTPS://shop.example.com", false},
+		{"javascript:alert(1)", true},
+		{"data:text/html,<script>alert(1)</script>", true},
+		{"ftp://shop.example.com/file", true},
+		{"//shop.example.com/p/1", true},
+		{"/p/1", true},
+		{"https://", true},
+		{"https://shop example.com/", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.to, func(t *testing.T) {
+			if err := validateTarget(tt.to); (err != nil) != tt.wantErr {
+				t.Errorf("validateTarget(%q) = %v, wantErr %v", tt.to, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"shop.example.com", ".unigoods.im", " Store.Example.org "}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"shop.example.com", true},
+		{"SHOP.example.com", true},
+		{"shop.example.com.", true},
+		{"www.shop.example.com", false},
+		{"example.com", false},
+		{"unigoods.im", true},
+		{"www.unigoods.im", true},
+		{"a.b.unigoods.im", true},
+		{"notunigoods.im", false},
+		{"store.example.org", true},
+		{"evil.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := hostAllowed(allowed, tt.host); got != tt.want {
+				t.Errorf("hostAllowed(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidateTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		to      string
+		wantErr bool
+	}{
+		{"no allow list", nil, "https://anything.example.net/", false},
+		{"listed", []string{".unigoods.im"}, "https://shop.unigoods.im/p", false},
+		{"listed with port", []string{"shop.example.com"}, "https://shop.example.com:8443/p", false},
+		{"not listed", []string{".unigoods.im"}, "https://evil.example.com/p", true},
+		{"userinfo trick", []string{"shop.example.com"}, "https://shop.example.com@evil.example.com/", true},
+		{"invalid target", []string{"shop.example.com"}, "javascript:alert(1)", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{AllowedHosts: tt.allowed}
+			if err := cfg.validateTarget(tt.to); (err != nil) != tt.wantErr {
+				t.Errorf("validateTarget(%q) = %v, wantErr %v", tt.to, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   Route
+		wantErr string
+	}{
+		{"no variants", Route{To: "https://shop.example.com/a"}, ""},
+		{"variants", Route{To: "https://shop.example.com/a", Variants: []Variant{{To: "https://shop.example.com/a1", Weight: 2}, {To: "https://shop.example.com/a2"}}}, ""},
+		{"bad target", Route{To: "javascript:alert(1)", Variants: []Variant{{To: "https://shop.example.com/a1"}}}, `target "javascript:alert(1)"`},
+		{"bad variant", Route{To: "https://shop.example.com/a", Variants: []Variant{{To: "https://shop.example.com/a1"}, {To: "ftp://shop.example.com/a2"}}}, "variant 2: "},
+		{"variant not allowed", Route{To: "https://shop.example.com/a", Variants: []Variant{{To: "https://evil.example.com/a1"}}}, "variant 1: "},
+		{"bad robots", Route{To: "https://shop.example.com/a", Robots: "noindex, nofolow"}, `unknown directive "nofolow"`},
+		{"negative weight", Route{To: "https://shop.example.com/a", Variants: []Variant{{To: "https://shop.example.com/a1", Weight: -1}}}, "variant 1: weight -1 is negative"},
+	}
+	cfg := &Config{AllowedHosts: []string{"shop.example.com"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := cfg.validateRoute(tt.route)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateRoute() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateRoute() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRobots(t *testing.T) {
+	tests := []struct {
+		robots  string
+		wantErr bool
+	}{
+		{"", false},
+		{"  ", false},
+		{"noindex", false},
+		{"noindex, nofollow", false},
+		{"NoIndex,NoFollow", false},
+		{"max-snippet:50, max-image-preview:large", false},
+		{"unavailable_after: 2025-12-31", false},
+		{"noindex, nofolow", true},
+		{"noindex,", true},
+		{"index follow", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.robots, func(t *testing.T) {
+			if err := validateRobots(tt.robots); (err != nil) != tt.wantErr {
+				t.Errorf("validateRobots(%q) = %v, wantErr %v", tt.robots, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	routes := map[string]Route{"/a": {To: "https://shop.example.com/a"}}
+	tests := []struct {
+		name       string
+		cfg        Config
+		allowEmpty bool
+		wantErr    error
+		wantCode   int
+	}{
+		{"CNAME", Config{CNAME: "s.example.com", Routes: routes}, false, nil, 0},
+		{"base URL", Config{BaseURL: "https://s.example.com", Routes: routes}, false, nil, 0},
+		{"no base URL", Config{Routes: routes}, false, errNoBaseURL, exitConfig},
+		{"no routes", Config{CNAME: "s.example.com"}, false, errNoRoutes, exitConfig},
+		{"empty routes", Config{CNAME: "s.example.com", Routes: map[string]Route{}}, false, errNoRoutes, exitConfig},
+		{"allow empty", Config{CNAME: "s.example.com"}, true, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.checkConfig(&options{cfgPath: "routes.json", allowEmpty: tt.allowEmpty})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkConfig() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && exitCode(err) != tt.wantCode {
+				t.Errorf("exit code %d, want %d", exitCode(err), tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want int // problems found
+	}{
+		{"valid", Config{CNAME: "s.example.com", Routes: map[string]Route{"/a": {To: "https://shop.example.com/a"}}}, 0},
+		{
+			name: "every bad route is reported",
+			cfg: Config{CNAME: "s.example.com", Routes: map[string]Route{
+				"/a": {To: "javascript:alert(1)"},
+				"/b": {To: " "},
+				"/c": {To: "https://shop.example.com/c"},
+			}},
+			want: 2,
+		},
+		{
+			name: "default redirect",
+			cfg:  Config{CNAME: "s.example.com", DefaultRedirect: "ftp://shop.example.com", Routes: map[string]Route{"/a": {To: "https://shop.example.com/a"}}},
+			want: 1,
+		},
+		{"no routes", Config{CNAME: "s.example.com"}, 1},
+		{
+			name: "allowed hosts",
+			cfg: Config{CNAME: "s.example.com", AllowedHosts: []string{"shop.example.com"}, Routes: map[string]Route{
+				"/a": {To: "https://shop.example.com/a"},
+				"/b": {To: "https://elsewhere.example.com/b"},
+			}},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errs := validateConfig(&tt.cfg, false); len(errs) != tt.want {
+				t.Errorf("validateConfig() = %q, want %d problem(s)", errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteCollisions(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []string
+		want   []string
+	}{
+		{"distinct", []string{"/a", "/b", "/a/b"}, nil},
+		{"slashes", []string{"/a", "a", "/a/"}, []string{`routes ["/a" "/a/" "a"] all map to /a`}},
+		{"root", []string{"/", ""}, []string{`routes ["" "/"] all map to /`}},
+		{"two groups", []string{"a", "/a", "b/", "/b"}, []string{`routes ["/a" "a"] all map to /a`, `routes ["/b" "b/"] all map to /b`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Routes: map[string]Route{}}
+			for _, p := range tt.routes {
+				cfg.Routes[p] = Route{To: "https://shop.example.com" + p}
+			}
+			var got []string
+			for _, err := range routeCollisions(&cfg) {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("routeCollisions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeRouteCollisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		routes   []string
+		want     []string // surviving keys
+		wantWarn []string
+	}{
+		{"distinct", []string{"/a", "/b"}, []string{"/a", "/b"}, nil},
+		{"last in sorted order wins", []string{"/a", "a", "/a/"}, []string{"a"}, []string{
+			`route "/a/" overrides "/a" (both map to /a)`,
+			`route "a" overrides "/a/" (both map to /a)`,
+		}},
+		{"root", []string{"", "/"}, []string{"/"}, []string{`route "/" overrides "" (both map to /)`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Routes: map[string]Route{}}
+			for _, p := range tt.routes {
+				cfg.Routes[p] = Route{To: "https://shop.example.com/" + p}
+			}
+			logs := captureLog(t)
+			mergeRouteCollisions(&cfg)
+			if got := sortedRoutes(&cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("routes = %q, want %q", got, tt.want)
+			}
+			for _, p := range tt.want {
+				if cfg.Routes[p].To != "https://shop.example.com/"+p {
+					t.Errorf("route %q kept another key's target %q", p, cfg.Routes[p].To)
+				}
+			}
+			var warns []string
+			for _, line := range strings.Split(logs.String(), "\n") {
+				if _, msg, ok := strings.Cut(line, "warn: "); ok {
+					warns = append(warns, msg)
+				}
+			}
+			if !reflect.DeepEqual(warns, tt.wantWarn) {
+				t.Errorf("warnings = %q, want %q", warns, tt.wantWarn)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// imageVerifier checks that og:image URLs still answer with an image, so a
+// dead image doesn't ship as a blank card. Results are remembered per URL
+// since routes often share one, e.g. GlobalOG. A nil *imageVerifier checks
+// nothing.
+type imageVerifier struct {
+	ctx  context.Context
+	f    *fetcher
+	done map[string]error
+}
+
+func newImageVerifier(ctx context.Context, f *fetcher) *imageVerifier {
+	return &imageVerifier{ctx: ctx, f: f, done: map[string]error{}}
+}
+
+// apply verifies og.Image and replaces it with GlobalOG, resolved against
+// the site's base URL, when it is not a reachable image. The dimensions
+// and alt text described the old image, so they are dropped with it.
+func (v *imageVerifier) apply(cfg *Config, og OG, routePath string) OG {
+	if v == nil || og.Image == "" {
+		return og
+	}
+	err := v.verify(og.Image)
+	if err == nil {
+		return og
+	}
+	fallback := cfg.globalOG()
+	if fallback == "" || fallback == og.Image {
+		warnf("image for %s: %v", routePath, err)
+		return og
+	}
+	if ferr := v.verify(fallback); ferr != nil {
+		warnf("image for %s: %v (globalOG is unusable too: %v)", routePath, err, ferr)
+		return og
+	}
+	warnf("image for %s: %v (using globalOG)", routePath, err)
+	og.Image = fallback
+	og.ImageWidth, og.ImageHeight, og.ImageAlt = 0, 0, ""
+	return og
+}
+
+func (v *imageVerifier) verify(src string) error {
+	if err, ok := v.done[src]; ok {
+		return err
+	}
+	err := v.check(src, http.MethodHead)
+	var se *statusError
+	if errors.As(err, &se) && (se.Code == http.StatusMethodNotAllowed || se.Code == http.StatusNotImplemented) {
+		// some image hosts only answer GET; the body is never read
+		err = v.check(src, http.MethodGet)
+	}
+	v.done[src] = err
+	return err
+}
+
+func (v *imageVerifier) check(src, method string) error {
+	if err := v.f.limit.wait(v.ctx, src); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(v.ctx, method, src, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", v.f.userAgent)
+	res, err := v.f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &statusError{Code: res.StatusCode, URL: src}
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		return fmt.Errorf("%s is %q, not an image", src, mediaType)
+	}
+	return nil
+}
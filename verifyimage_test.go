@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestImageVerifier(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/img.png", "/global.png", "/site/global.png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		case "/get-only.png":
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		image    string // %[1]s is the test server's URL
+		globalOG string // so is %[1]s here
+		want     string // and here
+		wantWarn string // empty when nothing is logged
+		wantHits map[string]int
+	}{
+		{
+			name:     "reachable image",
+			image:    "%[1]s/img.png",
+			globalOG: "%[1]s/global.png",
+			want:     "%[1]s/img.png",
+			wantHits: map[string]int{"HEAD /img.png": 1},
+		},
+		{
+			name:     "dead image",
+			image:    "%[1]s/gone.png",
+			globalOG: "%[1]s/global.png",
+			want:     "%[1]s/global.png",
+			wantWarn: "responded 404 Not Found (using globalOG)",
+			wantHits: map[string]int{"HEAD /gone.png": 1, "HEAD /global.png": 1},
+		},
+		{
+			name:     "not an image",
+			image:    "%[1]s/page",
+			globalOG: "%[1]s/global.png",
+			want:     "%[1]s/global.png",
+			wantWarn: `is "text/html", not an image (using globalOG)`,
+			wantHits: map[string]int{"HEAD /page": 1, "HEAD /global.png": 1},
+		},
+		{
+			name:     "HEAD not allowed",
+			image:    "%[1]s/get-only.png",
+			globalOG: "%[1]s/global.png",
+			want:     "%[1]s/get-only.png",
+			wantHits: map[string]int{"HEAD /get-only.png": 1, "GET /get-only.png": 1},
+		},
+		{
+			name:     "relative globalOG",
+			image:    "%[1]s/gone.png",
+			globalOG: "global.png",
+			want:     "%[1]s/site/global.png",
+			wantWarn: "(using globalOG)",
+			wantHits: map[string]int{"HEAD /gone.png": 1, "HEAD /site/global.png": 1},
+		},
+		{
+			name:     "root-relative globalOG",
+			image:    "%[1]s/gone.png",
+			globalOG: "/global.png",
+			want:     "%[1]s/global.png",
+			wantWarn: "(using globalOG)",
+			wantHits: map[string]int{"HEAD /gone.png": 1, "HEAD /global.png": 1},
+		},
+		{
+			name:     "no globalOG",
+			image:    "%[1]s/gone.png",
+			want:     "%[1]s/gone.png",
+			wantWarn: "responded 404 Not Found",
+			wantHits: map[string]int{"HEAD /gone.png": 1},
+		},
+		{
+			name:     "dead globalOG",
+			image:    "%[1]s/gone.png",
+			globalOG: "%[1]s/page",
+			want:     "%[1]s/gone.png",
+			wantWarn: "globalOG is unusable too",
+			wantHits: map[string]int{"HEAD /gone.png": 1, "HEAD /page": 1},
+		},
+		{
+			name:     "globalOG is the image",
+			image:    "%[1]s/gone.png",
+			globalOG: "%[1]s/gone.png",
+			want:     "%[1]s/gone.png",
+			wantWarn: "responded 404 Not Found",
+			wantHits: map[string]int{"HEAD /gone.png": 1},
+		},
+		{
+			name:     "no image",
+			globalOG: "%[1]s/global.png",
+			wantHits: map[string]int{},
+		},
+	}
+	sub := func(s string) string { return strings.ReplaceAll(s, "%[1]s", srv.URL) }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			clear(hits)
+			mu.Unlock()
+			logs := captureLog(t)
+			// the site is served from /site, the route's target is elsewhere
+			cfg := &Config{BaseURL: srv.URL + "/site", GlobalOG: sub(tt.globalOG)}
+			og := OG{Image: sub(tt.image), ImageWidth: 1200, ImageHeight: 630, ImageAlt: "alt"}
+			v := newImageVerifier(context.Background(), newFetcher(time.Second, ""))
+			// a second route with the same image is answered from memory
+			var got OG
+			for range 2 {
+				got = v.apply(cfg, og, "/a")
+			}
+			if want := sub(tt.want); got.Image != want {
+				t.Errorf("image = %q, want %q", got.Image, want)
+			}
+			if replaced := got.Image != og.Image; replaced != (got.ImageWidth == 0 && got.ImageAlt == "") {
+				t.Errorf("dimensions and alt = %d, %q after replacing %v", got.ImageWidth, got.ImageAlt, replaced)
+			}
+			if tt.wantWarn == "" {
+				if strings.Contains(logs.String(), "warn: ") {
+					t.Errorf("unexpected warning:\n%s", logs)
+				}
+			} else if !strings.Contains(logs.String(), "warn: image for /a: ") || !strings.Contains(logs.String(), tt.wantWarn) {
+				t.Errorf("log does not warn %q:\n%s", tt.wantWarn, logs)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(hits, tt.wantHits) {
+				t.Errorf("requests = %v, want %v", hits, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestNilImageVerifier(t *testing.T) {
+	var v *imageVerifier
+	og := OG{Image: "https://cdn.example.com/gone.png"}
+	if got := v.apply(&Config{GlobalOG: "https://cdn.example.com/g.png"}, og, "/a"); !reflect.DeepEqual(got, og) {
+		t.Errorf("apply() = %+v, want %+v", got, og)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce coalesces the burst of events editors emit for one save.
+const watchDebounce = 300 * time.Millisecond
+
+// watch generates once and then again whenever the config or template file
+// changes. Generation errors are logged and watching continues.
+func watch(ctx context.Context, o *options) error {
+	run := func() {
+		if err := generate(ctx, o); err != nil {
+			errorf("%v", err)
+		}
+		infof("watching %s for changes", o.cfgPath)
+	}
+	run()
+	return watchFiles(ctx, o, func() {
+		infof("change detected, regenerating")
+		run()
+	})
+}
+
+// watchFiles calls onChange after the config or a template file changes,
+// until ctx is done or the watcher fails.
+func watchFiles(ctx context.Context, o *options, onChange func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// watch the parent directories: editors often save by renaming a new
+	// file over the old one, which drops a watch on the file itself
+	files := map[string]bool{}
+	for _, p := range []string{o.cfgPath, o.templatePath, o.notFoundTemplatePath} {
+		if p == "" || p == "-" || isRemoteConfig(p) {
+			continue
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		files[abs] = true
+		if err := w.Add(filepath.Dir(abs)); err != nil {
+			return err
+		}
+	}
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if abs, err := filepath.Abs(ev.Name); err == nil && files[abs] &&
+				ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				timer = time.After(watchDebounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			errorf("watch: %v", err)
+		case <-ctx.Done():
+			return nil
+		case <-timer:
+			timer = nil
+			onChange()
+		}
+	}
+}
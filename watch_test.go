@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startWatch runs watchFiles for o in the background and returns a channel
+// receiving one value per onChange call. It returns once a write to the
+// config file has been seen, so the watcher is known to be in place.
+func startWatch(t *testing.T, o *options) <-chan struct{} {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan struct{}, 16)
+	done := make(chan error, 1)
+	go func() { done <- watchFiles(ctx, o, func() { changes <- struct{}{} }) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watchFiles() = %v", err)
+		}
+	})
+	b, err := os.ReadFile(o.cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		if err := os.WriteFile(o.cfgPath, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-changes:
+			return changes
+		case <-time.After(2 * watchDebounce):
+		case <-deadline:
+			t.Fatal("watcher never saw the config change")
+		}
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		change      func(t *testing.T, o *options)
+		wantChanges int
+	}{
+		{
+			name: "burst of writes",
+			change: func(t *testing.T, o *options) {
+				for i := 0; i < 3; i++ {
+					writeFile(t, o.cfgPath, fmt.Sprintf(`{"routes": {"/a": "https://shop.example.com/%d"}}`, i))
+				}
+			},
+			wantChanges: 1,
+		},
+		{
+			name: "saved by rename",
+			change: func(t *testing.T, o *options) {
+				tmp := o.cfgPath + ".tmp"
+				writeFile(t, tmp, `{"routes": {}}`)
+				if err := os.Rename(tmp, o.cfgPath); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantChanges: 1,
+		},
+		{
+			name:        "template",
+			change:      func(t *testing.T, o *options) { writeFile(t, o.templatePath, "<p>{{.Title}}</p>") },
+			wantChanges: 1,
+		},
+		{
+			name:   "unrelated file",
+			change: func(t *testing.T, o *options) { writeFile(t, filepath.Join(filepath.Dir(o.cfgPath), "notes.txt"), "x") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, `{"routes": {}}`)
+			o.templatePath = filepath.Join(t.TempDir(), "page.html")
+			writeFile(t, o.templatePath, "<p>{{.Description}}</p>")
+			changes := startWatch(t, o)
+			tt.change(t, o)
+			got := 0
+			timeout := time.After(4 * watchDebounce)
+		loop:
+			for {
+				select {
+				case <-changes:
+					got++
+				case <-timeout:
+					break loop
+				}
+			}
+			if got != tt.wantChanges {
+				t.Errorf("%d change(s) reported, want %d", got, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	site := newSite(t, map[string]string{"/p": `<meta property="og:title" content="P">`})
+	o := testOptions(t, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%s/p"}}`, site.URL))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watch(ctx, o) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watch() = %v", err)
+		}
+	}()
+
+	waitForFile(t, filepath.Join(o.outDir, "a", "index.html"))
+	// a broken config is logged and watching goes on
+	time.Sleep(watchDebounce)
+	writeFile(t, o.cfgPath, `{"routes": `)
+	time.Sleep(3 * watchDebounce)
+	writeFile(t, o.cfgPath, fmt.Sprintf(`{"cname": "s.example.com", "routes": {"/a": "%[1]s/p", "/b": "%[1]s/p"}}`, site.URL))
+	waitForFile(t, filepath.Join(o.outDir, "b", "index.html"))
+}
+
+func writeFile(t *testing.T, path, s string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s was not written", path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
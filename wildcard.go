@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// wildcardRoute is a route key ending in "*", such as "/p/*", which forwards
+// whatever follows Prefix onto To: /p/123 goes to To + "123".
+type wildcardRoute struct {
+	Prefix string `json:"prefix"`
+	To     string `json:"to"`
+}
+
+// wildcardPrefix reports whether route key p is a wildcard and returns the
+// public path prefix it matches. A slash before the "*" is kept, so "/p/*"
+// matches /p/123 but not /px.
+func (c *Config) wildcardPrefix(p string) (string, bool) {
+	prefix, ok := strings.CutSuffix(strings.TrimSpace(p), "*")
+	if !ok {
+		return "", false
+	}
+	rp := c.routePath(prefix)
+	if strings.HasSuffix(prefix, "/") || rp == "" {
+		rp += "/"
+	}
+	return rp, true
+}
+
+// pagePath is where the page for route key p is written: its route path, or
+// for a wildcard the path of its prefix.
+func (c *Config) pagePath(p string) string {
+	if _, ok := c.wildcardPrefix(p); ok {
+		return c.routePath(strings.TrimSuffix(strings.TrimSpace(p), "*"))
+	}
+	return c.routePath(p)
+}
+
+// pageRoutes is sortedRoutes without wildcards whose prefix page an exact
+// route already claims; exact routes always take precedence.
+func (c *Config) pageRoutes() []string {
+	exact := map[string]bool{}
+	for p := range c.Routes {
+		if _, ok := c.wildcardPrefix(p); !ok {
+			exact[c.routePath(p)] = true
+		}
+	}
+	var keys []string
+	for _, p := range sortedRoutes(c) {
+		if _, ok := c.wildcardPrefix(p); ok && exact[c.pagePath(p)] {
+			continue
+		}
+		keys = append(keys, p)
+	}
+	return keys
+}
+
+// wildcards returns the wildcard routes longest prefix first, the order in
+// which they must be tried.
+func (c *Config) wildcards() []wildcardRoute {
+	var ws []wildcardRoute
+	for _, p := range sortedRoutes(c) {
+		if prefix, ok := c.wildcardPrefix(p); ok {
+			r := c.Routes[p]
+			ws = append(ws, wildcardRoute{Prefix: prefix, To: c.withUTM(r, r.To)})
+		}
+	}
+	sort.SliceStable(ws, func(i, j int) bool { return len(ws[i].Prefix) > len(ws[j].Prefix) })
+	return ws
+}
+
+// redirectOrder lists route keys for first-match redirect rules: exact routes
+// in lexical order, then wildcards longest prefix first.
+func (c *Config) redirectOrder() []string {
+	var exact, wild []string
+	for _, p := range sortedRoutes(c) {
+		if _, ok := c.wildcardPrefix(p); ok {
+			wild = append(wild, p)
+		} else {
+			exact = append(exact, p)
+		}
+	}
+	sort.SliceStable(wild, func(i, j int) bool {
+		a, _ := c.wildcardPrefix(wild[i])
+		b, _ := c.wildcardPrefix(wild[j])
+		return len(a) > len(b)
+	})
+	return append(exact, wild...)
+}
+
+// forwardSuffix appends the matched remainder of a wildcard path to the path
+// of to, ahead of any query or fragment it carries.
+func forwardSuffix(to, rest string) string {
+	if i := strings.IndexAny(to, "?#"); i >= 0 {
+		return to[:i] + rest + to[i:]
+	}
+	return to + rest
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWildcardPrefix(t *testing.T) {
+	tests := []struct {
+		name, basePath, key string
+		want                string
+		wantOK              bool
+	}{
+		{"exact route", "", "/p", "", false},
+		{"after a slash", "", "/p/*", "/p/", true},
+		{"no slash", "", "/p*", "/p", true},
+		{"no leading slash", "", "p/*", "/p/", true},
+		{"root", "", "/*", "/", true},
+		{"bare star", "", "*", "/", true},
+		{"base path", "shop", "/p/*", "/shop/p/", true},
+		{"base path root", "shop", "/*", "/shop/", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{BasePath: tt.basePath}
+			got, ok := cfg.wildcardPrefix(tt.key)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("wildcardPrefix(%q) = %q, %v, want %q, %v", tt.key, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestForwardSuffix(t *testing.T) {
+	tests := []struct {
+		to, rest, want string
+	}{
+		{"https://shop.example.com/products/", "123", "https://shop.example.com/products/123"},
+		{"https://shop.example.com/products/", "", "https://shop.example.com/products/"},
+		{"https://shop.example.com/products/?ref=sl", "123", "https://shop.example.com/products/123?ref=sl"},
+		{"https://shop.example.com/products/#top", "a/b", "https://shop.example.com/products/a/b#top"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.to+"+"+tt.rest, func(t *testing.T) {
+			if got := forwardSuffix(tt.to, tt.rest); got != tt.want {
+				t.Errorf("forwardSuffix(%q, %q) = %q, want %q", tt.to, tt.rest, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWildcardOrder(t *testing.T) {
+	cfg := &Config{Routes: map[string]Route{
+		"/p/*":     {To: "https://shop.example.com/products/"},
+		"/p/new/*": {To: "https://shop.example.com/new/"},
+		"/p/new":   {To: "https://shop.example.com/new-arrivals"},
+		"/a":       {To: "https://shop.example.com/a"},
+		"/*":       {To: "https://shop.example.com/"},
+	}}
+	if got, want := cfg.redirectOrder(), []string{"/a", "/p/new", "/p/new/*", "/p/*", "/*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("redirectOrder() = %q, want %q", got, want)
+	}
+	var prefixes []string
+	for _, w := range cfg.wildcards() {
+		prefixes = append(prefixes, w.Prefix)
+	}
+	if want := []string{"/p/new/", "/p/", "/"}; !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("wildcards() = %q, want %q", prefixes, want)
+	}
+	// the exact /p/new claims the page the /p/new/* wildcard would get
+	if got, want := cfg.pageRoutes(), []string{"/*", "/a", "/p/*", "/p/new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pageRoutes() = %q, want %q", got, want)
+	}
+}
+
+func TestWildcardMatcher(t *testing.T) {
+	cfg := &Config{CNAME: "s.example.com", DefaultRedirect: "https://shop.example.com/", Routes: map[string]Route{
+		"/p/*":     {To: "https://shop.example.com/products/?ref=sl"},
+		"/p/new/*": {To: "https://shop.example.com/new/"},
+	}}
+	page, err := build404HTML(nil, cfg, OG{Title: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, href, want string
+	}{
+		{"suffix forwarded", "https://s.example.com/p/123", "https://shop.example.com/products/123?ref=sl"},
+		{"longest prefix wins", "https://s.example.com/p/new/9", "https://shop.example.com/new/9"},
+		{"query merged", "https://s.example.com/p/123?utm_source=ig", "https://shop.example.com/products/123?ref=sl&utm_source=ig"},
+		{"no match", "https://s.example.com/x", "https://shop.example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runRedirectScript(t, page, tt.href); got != tt.want {
+				t.Errorf("redirected to %q, want %q", got, tt.want)
+			}
+		})
+	}
+}